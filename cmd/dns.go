@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/issmirnov/zap/cmd/zap"
+)
+
+// runDNS implements "zap dns export [-config c.yml] [-format hosts] [-advertise 127.0.0.1] [-ttl 60]".
+// It prints DNS records for the top-level shortcuts in the format of another DNS server, and
+// returns the process exit code.
+func runDNS(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("dns", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configName := fs.String("config", "c.yml", "config file")
+	format := fs.String("format", "hosts", "output format, one of "+strings.Join(zap.DNSExportFormats, ", "))
+	advertise := fs.String("advertise", "127.0.0.1", "comma separated IPv4 and IPv6 addresses the shortcuts resolve to")
+	ttl := fs.Uint("ttl", 60, "TTL of the records in seconds, for formats that have one")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s dns export [flags]\n\nExample: %s dns export -config c.yml -format dnsmasq -advertise 192.168.1.10\n\nFlags:\n", appName, appName)
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "export" {
+		fs.Usage()
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	addrs, err := zap.ParseAddrs(*advertise)
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid -advertise: %v\n", err)
+		return 2
+	}
+	if *ttl > 1<<31-1 {
+		fmt.Fprintf(stderr, "Invalid -ttl %d, must be below 2^31 seconds\n", *ttl)
+		return 2
+	}
+
+	c, err := loadConfig(*configName)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load config:\n%s\n", err)
+		return 1
+	}
+	skipped, err := zap.ExportDNS(stdout, *format, c, addrs, uint32(*ttl))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	for _, k := range skipped {
+		fmt.Fprintf(stderr, "Skipped shortcut '%s', it is not a valid host name\n", k)
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunDNS(t *testing.T) {
+	config := writeConfig(t, testConfig)
+	underscores := writeConfig(t, testConfig+"my_app:\n  expand: example.com\n")
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout []string
+		stderr []string
+	}{
+		{
+			name:   "default format",
+			args:   []string{"export", "-config", config},
+			stdout: []string{"### Zap Shortcuts :start ##\n", "127.0.0.1 ak\n", "127.0.0.1 g\n", "### Zap Shortcuts :end ##\n"},
+		},
+		{
+			name:   "dnsmasq",
+			args:   []string{"export", "-config", config, "-format", "dnsmasq", "-advertise", "192.0.2.10,2001:db8::10"},
+			stdout: []string{"# Generated by zap", "address=/ak/192.0.2.10\n", "address=/ak/2001:db8::10\n", "address=/g/192.0.2.10\n"},
+		},
+		{
+			name:   "ttl",
+			args:   []string{"export", "-config", config, "-format", "unbound", "-ttl", "300"},
+			stdout: []string{"server:\n", `local-data: "ak. 300 IN A 127.0.0.1"`},
+		},
+		{
+			name:   "keys that aren't host names",
+			args:   []string{"export", "-config", underscores, "-format", "dnsmasq"},
+			stdout: []string{"address=/g/127.0.0.1\n"},
+			stderr: []string{"Skipped shortcut 'my_app', it is not a valid host name\n"},
+		},
+		{
+			name:   "unknown format",
+			args:   []string{"export", "-config", config, "-format", "djbdns"},
+			code:   2,
+			stderr: []string{"unsupported DNS export format 'djbdns'"},
+		},
+		{
+			name:   "invalid advertise",
+			args:   []string{"export", "-config", config, "-advertise", "localhost"},
+			code:   2,
+			stderr: []string{"Invalid -advertise: invalid address 'localhost'"},
+		},
+		{
+			name:   "empty advertise",
+			args:   []string{"export", "-config", config, "-advertise", ""},
+			code:   2,
+			stderr: []string{"Invalid -advertise: empty entry in ''"},
+		},
+		{
+			name:   "ttl out of range",
+			args:   []string{"export", "-config", config, "-ttl", "2147483648"},
+			code:   2,
+			stderr: []string{"Invalid -ttl 2147483648"},
+		},
+		{
+			name:   "missing config",
+			args:   []string{"export", "-config", filepath.Join(t.TempDir(), "missing.yml")},
+			code:   1,
+			stderr: []string{"Failed to load config:"},
+		},
+		{
+			name:   "no subcommand",
+			code:   2,
+			stderr: []string{"Usage: zap dns export"},
+		},
+		{
+			name:   "extra arguments",
+			args:   []string{"export", "-config", config, "g"},
+			code:   2,
+			stderr: []string{"Usage: zap dns export"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runDNS(tt.args, &stdout, &stderr); code != tt.code {
+				t.Errorf("exit code = %d, want %d\nstderr: %s", code, tt.code, stderr.String())
+			}
+			checkOutput(t, "stdout", stdout.String(), tt.stdout)
+			checkOutput(t, "stderr", stderr.String(), tt.stderr)
+		})
+	}
+}
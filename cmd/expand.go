@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/issmirnov/zap/cmd/zap"
+)
+
+// loadConfig parses and validates a config file, the same way the server does on startup.
+func loadConfig(fname string) (*zap.Node, error) {
+	c, err := zap.ParseYaml(fname)
+	if err != nil {
+		return nil, err
+	}
+	if err := zap.ValidateConfig(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// runExpand implements "zap expand [-config c.yml] [-json] shortcut...". It prints the URL every
+// shortcut resolves to, one per line, and returns the process exit code.
+func runExpand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("expand", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configName := fs.String("config", "c.yml", "config file")
+	asJSON := fs.Bool("json", false, "print the full expansion trace of every shortcut as a JSON object per line")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s expand [flags] shortcut...\n\nExample: %s expand -config c.yml g/s/foo a/o\n\nFlags:\n", appName, appName)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	c, err := loadConfig(*configName)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load config:\n%s\n", err)
+		return 1
+	}
+
+	resolver := &zap.Resolver{Config: c, Trace: *asJSON}
+	enc := json.NewEncoder(stdout)
+	code := 0
+	for _, q := range fs.Args() {
+		res, err := resolver.ResolveShortcut(q)
+		if err != nil {
+			code = 1
+			if *asJSON {
+				_ = enc.Encode(struct {
+					Query string `json:"query"`
+					Error string `json:"error"`
+				}{q, err.Error()})
+			} else {
+				fmt.Fprintf(stderr, "%s: %s\n", q, err)
+			}
+			continue
+		}
+
+		if *asJSON {
+			_ = enc.Encode(zap.Preview{Query: q, Result: res})
+		} else {
+			fmt.Fprintln(stdout, res.URL)
+		}
+	}
+	return code
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+g:
+  expand: github.com
+  z:
+    expand: issmirnov/zap
+  s:
+    query: "search?q="
+ak:
+  expand: kafka.apache.org
+`
+
+// writeConfig writes a config file for a test and returns its name.
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	fname := filepath.Join(t.TempDir(), "c.yml")
+	if err := os.WriteFile(fname, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func TestRunExpand(t *testing.T) {
+	config := writeConfig(t, testConfig)
+	bad := writeConfig(t, "g:\n  expand: [github.com]\n")
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout []string // substrings expected on stdout, in order
+		stderr []string // substrings expected on stderr
+	}{
+		{
+			name:   "shortcuts",
+			args:   []string{"-config", config, "g/z", "g/s/foo bar?x=1"},
+			stdout: []string{"https://github.com/issmirnov/zap\n", "https://github.com/search?q=foo+bar&x=1\n"},
+		},
+		{
+			name:   "unknown shortcut",
+			args:   []string{"-config", config, "g/z", "gh/z"},
+			code:   1,
+			stdout: []string{"https://github.com/issmirnov/zap\n"},
+			stderr: []string{"gh/z: shortcut 'gh/z' not found in config"},
+		},
+		{
+			name:   "json",
+			args:   []string{"-config", config, "-json", "g/z", "gh"},
+			code:   1,
+			stdout: []string{`{"query":"g/z","url":"https://github.com/issmirnov/zap",`, `"steps":[{"token":"g"`, `"leftover":[]`, `{"query":"gh","error":"shortcut 'gh' not found in config"}`},
+		},
+		{
+			name:   "invalid config",
+			args:   []string{"-config", bad, "g"},
+			code:   1,
+			stderr: []string{"Failed to load config:", "g.expand:"},
+		},
+		{
+			name:   "no shortcuts",
+			args:   []string{"-config", config},
+			code:   2,
+			stderr: []string{"Usage: zap expand"},
+		},
+		{
+			name:   "unknown flag",
+			args:   []string{"-nope"},
+			code:   2,
+			stderr: []string{"flag provided but not defined: -nope"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runExpand(tt.args, &stdout, &stderr); code != tt.code {
+				t.Errorf("exit code = %d, want %d\nstderr: %s", code, tt.code, stderr.String())
+			}
+			checkOutput(t, "stdout", stdout.String(), tt.stdout)
+			checkOutput(t, "stderr", stderr.String(), tt.stderr)
+		})
+	}
+}
+
+// checkOutput fails the test unless out contains every string in want, in order. An empty
+// want means out must be empty.
+func checkOutput(t *testing.T, name, out string, want []string) {
+	t.Helper()
+	if len(want) == 0 {
+		if out != "" {
+			t.Errorf("unexpected %s: %q", name, out)
+		}
+		return
+	}
+	rest := out
+	for _, w := range want {
+		i := strings.Index(rest, w)
+		if i < 0 {
+			t.Errorf("%s = %q, missing %q", name, out, w)
+			return
+		}
+		rest = rest[i+len(w):]
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/issmirnov/zap/cmd/zap"
+)
+
+// runHosts implements "zap hosts uninstall [-hosts-file /etc/hosts]". It removes the shortcuts
+// the server wrote to the hosts file, and returns the process exit code.
+func runHosts(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("hosts", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	hostsFile := fs.String("hosts-file", zap.DefaultHostsFile, "hosts file to remove the shortcuts from")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s hosts uninstall [flags]\n\nFlags:\n", appName)
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "uninstall" {
+		fs.Usage()
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if err := zap.RemoveHosts(*hostsFile); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Removed zap shortcuts from '%s'\n", *hostsFile)
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunHosts(t *testing.T) {
+	const orig = "127.0.0.1 localhost\n"
+	const zapped = orig + "### Zap Shortcuts :start ##\n127.0.0.1 g\n### Zap Shortcuts :end ##\n"
+
+	tests := []struct {
+		name   string
+		hosts  string // initial contents of the hosts file, none if empty
+		args   []string
+		code   int
+		stdout []string
+		stderr []string
+		want   string // contents of the hosts file afterwards
+	}{
+		{
+			name:   "uninstall",
+			hosts:  zapped,
+			args:   []string{"uninstall"},
+			stdout: []string{"Removed zap shortcuts from '"},
+			want:   orig,
+		},
+		{
+			name:   "nothing to remove",
+			hosts:  orig,
+			args:   []string{"uninstall"},
+			stdout: []string{"Removed zap shortcuts from '"},
+			want:   orig,
+		},
+		{
+			name:   "unterminated block",
+			hosts:  orig + "### Zap Shortcuts :start ##\n127.0.0.1 g\n",
+			args:   []string{"uninstall"},
+			code:   1,
+			stderr: []string{"refusing to edit hosts file", "without a matching"},
+			want:   orig + "### Zap Shortcuts :start ##\n127.0.0.1 g\n",
+		},
+		{
+			name:   "missing file",
+			args:   []string{"uninstall"},
+			code:   1,
+			stderr: []string{"failed to read hosts file"},
+		},
+		{
+			name:   "no subcommand",
+			code:   2,
+			stderr: []string{"Usage: zap hosts uninstall"},
+		},
+		{
+			name:   "unknown subcommand",
+			args:   []string{"install"},
+			code:   2,
+			stderr: []string{"Usage: zap hosts uninstall"},
+		},
+		{
+			name:   "extra arguments",
+			hosts:  zapped,
+			args:   []string{"uninstall", "now"},
+			code:   2,
+			stderr: []string{"Usage: zap hosts uninstall"},
+			want:   zapped,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "hosts")
+			if tt.hosts != "" {
+				if err := os.WriteFile(fname, []byte(tt.hosts), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			args := tt.args
+			if len(args) > 0 && args[0] == "uninstall" {
+				args = append([]string{"uninstall", "-hosts-file", fname}, args[1:]...)
+			}
+
+			var stdout, stderr bytes.Buffer
+			if code := runHosts(args, &stdout, &stderr); code != tt.code {
+				t.Errorf("exit code = %d, want %d\nstderr: %s", code, tt.code, stderr.String())
+			}
+			checkOutput(t, "stdout", stdout.String(), tt.stdout)
+			checkOutput(t, "stderr", stderr.String(), tt.stderr)
+			if tt.hosts != "" {
+				data, err := os.ReadFile(fname)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != tt.want {
+					t.Errorf("hosts file = %q, want %q", data, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/issmirnov/zap/cmd/zap"
 
 	"github.com/fsnotify/fsnotify"
-
-	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const appName = "zap"
@@ -21,13 +28,42 @@ const appName = "zap"
 var version = "develop"
 
 func main() {
+	// Subcommands come before any flags, e.g. "zap expand -config c.yml g/s/foo".
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "expand":
+			os.Exit(runExpand(os.Args[2:], os.Stdout, os.Stderr))
+		case "stats":
+			os.Exit(runStats(os.Args[2:], os.Stdout, os.Stderr))
+		case "hosts":
+			os.Exit(runHosts(os.Args[2:], os.Stdout, os.Stderr))
+		case "dns":
+			os.Exit(runDNS(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	var (
 		configName = flag.String("config", "c.yml", "config file")
 		port       = flag.Int("port", 8927, "port to bind to")
-		host       = flag.String("host", "127.0.0.1", "host address to bind to")
-		advertise  = flag.String("advertise", "127.0.0.1", "IP to advertise, used in /etc/hosts")
+		host       = flag.String("host", "127.0.0.1", "comma separated IPv4 and IPv6 addresses to bind to, e.g. 127.0.0.1,::1")
+		advertise  = flag.String("advertise", "127.0.0.1", "comma separated IPv4 and IPv6 addresses to advertise, used in the hosts file and DNS answers")
+		hostsFile  = flag.String("hosts-file", zap.DefaultHostsFile, "keep the shortcuts in sync in this hosts file, see 'zap hosts uninstall'. Disabled if empty")
 		v          = flag.Bool("v", false, "print version info")
 		validate   = flag.Bool("validate", false, "load config file and check for errors")
+		proxyTTL   = flag.Duration("proxy-timeout", 30*time.Second, "connect and response header timeout for shortcuts in proxy mode")
+		statsFile  = flag.String("stats-file", "", "record shortcut usage in this file, see 'zap stats'. Disabled if empty")
+		statsEvery = flag.Duration("stats-interval", time.Minute, "how often to save usage stats")
+		accessLog  = flag.String("access-log", "", "write access logs to 'stdout', 'stderr' or a file. Disabled if empty")
+		logFormat  = flag.String("access-log-format", "logfmt", "access log format, 'logfmt' or 'json'")
+		logSample  = flag.Float64("access-log-sample", 1, "fraction of requests to log, from 0 to 1. Server errors are always logged")
+		trusted    = flag.String("trusted-proxies", "", "comma separated IPs and CIDR ranges allowed to set X-Forwarded-For, e.g. 127.0.0.1,10.0.0.0/8")
+		readTTL    = flag.Duration("read-timeout", 30*time.Second, "maximum duration for reading a request, including the body. 0 means no limit")
+		writeTTL   = flag.Duration("write-timeout", 0, "maximum duration for writing a response. 0 means no limit, so that proxied responses can stream")
+		idleTTL    = flag.Duration("idle-timeout", 2*time.Minute, "how long to keep idle keep-alive connections open")
+		reloadKey  = flag.String("reload-token", os.Getenv("ZAP_RELOAD_TOKEN"), "enables POST /_zap/reload with this bearer token. Defaults to $ZAP_RELOAD_TOKEN")
+		dnsAddr    = flag.String("dns", "", "answer DNS queries for shortcut names with the advertised address on this address, e.g. :5353. Disabled if empty")
+		dnsForward = flag.String("dns-upstream", "", "forward other DNS queries to this resolver, e.g. 1.1.1.1:53. Refused if empty")
+		drainTTL   = flag.Duration("shutdown-timeout", 15*time.Second, "how long to wait for in-flight requests on SIGTERM or SIGINT")
 	)
 	flag.Parse()
 
@@ -36,15 +72,11 @@ func main() {
 		os.Exit(0)
 	}
 
-	// load config for first time.
-	c, err := zap.ParseYaml(*configName)
-	if err != nil {
-		log.Fatalf("Error parsing config file '%s'. Please fix syntax: %s\n", *configName, err)
-	}
+	// load config for first time. Parsing compiles the shortcut tree and reports type errors.
+	c, err := loadConfig(*configName)
 
-	// Perform extended validation of config.
 	if *validate {
-		if err := zap.ValidateConfig(c); err != nil {
+		if err != nil {
 			fmt.Printf("Configuration validation failed:\n%s\n", err.Error())
 			os.Exit(1)
 		}
@@ -53,18 +85,56 @@ func main() {
 	}
 
 	// Validate config before starting server
-	if err := zap.ValidateConfig(c); err != nil {
+	if err != nil {
 		log.Fatalf("Configuration validation failed. Please fix errors before starting server:\n%s\n", err.Error())
 	}
 
-	context := &zap.Context{Config: c, Advertise: *advertise}
+	advertised, err := zap.ParseAddrs(*advertise)
+	if err != nil {
+		log.Fatalf("Invalid -advertise: %v", err)
+	}
+	// An empty -host would bind every interface, so ParseAddrs rejects empty entries.
+	binds, err := zap.ParseAddrs(*host)
+	if err != nil {
+		log.Fatalf("Invalid -host: %v", err)
+	}
+
+	// Background work stops once the server has drained, see below.
+	bg, stopBackground := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	zapCtx := zap.NewContext(c)
+	zapCtx.AdvertiseAddrs = advertised
+	zapCtx.ProxyTransport = zap.NewProxyTransport(*proxyTTL)
+	zapCtx.HostsFile = *hostsFile
+	zapCtx.ConfigFile = *configName
+	zapCtx.ReloadToken = *reloadKey
+
+	// Usage stats survive restarts, so pick up where the last run left off.
+	if *statsFile != "" {
+		stats, err := zap.LoadStats(*statsFile)
+		if err != nil {
+			log.Fatalf("Failed to load usage stats: %v", err)
+		}
+		zapCtx.Stats = stats
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stats.Run(bg, *statsFile, *statsEvery, log.Printf)
+		}()
+	}
 
-	// Try to update hosts file, but don't fail if we can't
-	if err := zap.UpdateHosts(context); err != nil {
-		log.Printf("Warning: Failed to update /etc/hosts file: %v", err)
-		log.Println("Server will continue running, but DNS shortcuts may not work")
+	if *accessLog != "" {
+		al, err := newAccessLog(*accessLog, *logFormat, *logSample, *trusted)
+		if err != nil {
+			log.Fatalf("Failed to set up access log: %v", err)
+		}
+		zapCtx.AccessLog = al
 	}
 
+	// Set up routes. This also syncs the hosts file for the first time.
+	router := SetupRouter(zapCtx, zap.WithRegisterer(prometheus.DefaultRegisterer))
+
 	// Enable hot reload.
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -76,37 +146,136 @@ func main() {
 		}
 	}()
 
-	cb := zap.MakeReloadCallback(context, *configName)
-	go zap.WatchConfigFileChanges(watcher, *configName, cb)
+	cb := zap.MakeReloadCallback(zapCtx, *configName)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		zap.WatchConfigFileChanges(bg, watcher, *configName, cb)
+	}()
 	err = watcher.Add(path.Dir(*configName))
 	if err != nil {
 		log.Fatalf("Failed to watch config directory: %v", err)
 	}
 
-	// Set up routes.
-	router := SetupRouter(context)
+	// fsnotify is unreliable on some network filesystems and volume mounts, so SIGHUP
+	// reloads too.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				log.Printf("Received SIGHUP, reloading '%s'...", *configName)
+				cb()
+			case <-bg.Done():
+				return
+			}
+		}
+	}()
+
+	if *dnsAddr != "" {
+		dnsServer := zap.NewDNSServer(zapCtx, *dnsForward)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := dnsServer.ListenAndServe(bg, *dnsAddr); err != nil {
+				log.Fatalf("DNS server failed: %v", err)
+			}
+		}()
+		fmt.Printf("DNS server: %s\n", *dnsAddr)
+	}
 
-	// Start the server
-	serverAddr := fmt.Sprintf("%s:%d", *host, *port)
-	fmt.Printf("Launching %s on %s\n", appName, serverAddr)
+	// Bind every address before serving, so that a typo fails fast.
+	var listeners []net.Listener
+	for _, a := range binds {
+		l, err := net.Listen("tcp", net.JoinHostPort(a.String(), strconv.Itoa(*port)))
+		if err != nil {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+		listeners = append(listeners, l)
+	}
+	serverAddr := listeners[0].Addr().String()
+	for _, l := range listeners {
+		fmt.Printf("Launching %s on %s\n", appName, l.Addr())
+	}
 	fmt.Printf("Configuration file: %s\n", *configName)
 	fmt.Printf("Health check: http://%s/healthz\n", serverAddr)
 	fmt.Printf("Configuration view: http://%s/varz\n", serverAddr)
+	fmt.Printf("Metrics: http://%s%s\n", serverAddr, zap.MetricsPath)
+	fmt.Printf("Shortcut directory: http://%s%s\n", serverAddr, zap.DirectoryPath)
+	fmt.Printf("Proxy auto-config: http://%s%s\n", serverAddr, zap.PACPath)
+
+	// Drain in-flight requests on SIGTERM (Kubernetes rollouts) and SIGINT (Ctrl-C).
+	sig, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stopSignals()
 
-	if err := http.ListenAndServe(serverAddr, router); err != nil {
+	srv := &http.Server{
+		Handler:      router,
+		ReadTimeout:  *readTTL,
+		WriteTimeout: *writeTTL,
+		IdleTimeout:  *idleTTL,
+	}
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { serveErr <- srv.Serve(l) }()
+	}
+
+	select {
+	case err := <-serveErr:
 		log.Fatalf("Server failed to start: %v", err)
+	case <-sig.Done():
+	}
+	stopSignals() // a second signal kills the process right away.
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", *drainTTL)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *drainTTL)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to drain connections: %v", err)
+	}
+
+	// Stop watching the config and save the usage stats one last time.
+	stopBackground()
+	wg.Wait()
+	log.Printf("Shutdown complete")
+}
+
+// newAccessLog builds the access log from the command line flags.
+func newAccessLog(dest, format string, sample float64, trusted string) (*zap.AccessLog, error) {
+	if sample < 0 || sample > 1 {
+		return nil, fmt.Errorf("sample rate must be between 0 and 1, got %v", sample)
+	}
+	proxies, err := zap.ParseTrustedProxies(trusted)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.Writer
+	switch dest {
+	case "stdout", "-":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		w = f
+	}
+
+	logger, err := zap.NewAccessLogger(w, format)
+	if err != nil {
+		return nil, err
 	}
+	return &zap.AccessLog{Logger: logger, SampleRate: sample, TrustedProxies: proxies}, nil
 }
 
-func SetupRouter(context *zap.Context) *httprouter.Router {
-	router := httprouter.New()
-	router.Handler("GET", "/", zap.CtxWrapper{Context: context, H: zap.IndexHandler})
-	router.Handler("GET", "/varz", zap.CtxWrapper{Context: context, H: zap.VarsHandler})
-	router.HandlerFunc("GET", "/healthz", zap.HealthHandler)
-
-	// https://github.com/julienschmidt/httprouter is having issues with
-	// wildcard handling. As a result, we have to register index handler
-	// as the fallback. Fix incoming.
-	router.NotFound = zap.CtxWrapper{Context: context, H: zap.IndexHandler}
-	return router
+// SetupRouter returns the zap handler serving context. It is kept for existing callers,
+// new code should use zap.NewHandler directly.
+func SetupRouter(context *zap.Context, opts ...zap.Option) http.Handler {
+	return zap.NewHandler(append([]zap.Option{zap.WithContext(context)}, opts...)...)
 }
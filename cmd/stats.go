@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/issmirnov/zap/cmd/zap"
+)
+
+// runStats implements "zap stats -stats-file stats.json [-config c.yml] [-n 10] [-json]".
+// It prints the most used shortcuts and the ones never used, and returns the process exit code.
+func runStats(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configName := fs.String("config", "c.yml", "config file")
+	statsFile := fs.String("stats-file", "", "usage stats file written by the server, the same as its -stats-file (required)")
+	n := fs.Int("n", 10, "number of most used shortcuts to show")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s stats [flags]\n\nFlags:\n", appName)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	// The server records nothing by default, so there is no file to fall back to.
+	if *statsFile == "" {
+		fmt.Fprintf(stderr, "-stats-file is required\n")
+		fs.Usage()
+		return 2
+	}
+
+	c, err := loadConfig(*configName)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load config:\n%s\n", err)
+		return 1
+	}
+	stats, err := zap.LoadStats(*statsFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	report := stats.Report(c, *n)
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+		return 0
+	}
+
+	fmt.Fprintf(stdout, "Most used shortcuts:\n")
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, u := range report.Top {
+		fmt.Fprintf(tw, "%d\t  %s\tlast used %s\t\n", u.Hits, u.Node, u.LastUsed.Local().Format(time.DateTime))
+	}
+	_ = tw.Flush()
+	if len(report.Top) == 0 {
+		fmt.Fprintf(stdout, "  none recorded in '%s'\n", *statsFile)
+	}
+
+	fmt.Fprintf(stdout, "\nNever used shortcuts:\n")
+	for _, path := range report.Unused {
+		fmt.Fprintf(stdout, "  %s\n", path)
+	}
+	if len(report.Unused) == 0 {
+		fmt.Fprintf(stdout, "  none\n")
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/issmirnov/zap/cmd/zap"
+)
+
+func TestRunStats(t *testing.T) {
+	config := writeConfig(t, testConfig)
+	statsFile := filepath.Join(t.TempDir(), "stats.json")
+	stats := zap.NewStats()
+	stats.Record("g.z", time.Now())
+	stats.Record("g.z", time.Now())
+	if err := stats.Save(statsFile); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout []string
+		stderr []string
+	}{
+		{
+			name:   "report",
+			args:   []string{"-config", config, "-stats-file", statsFile},
+			stdout: []string{"Most used shortcuts:\n", "2    g.z", "Never used shortcuts:\n", "  ak\n"},
+		},
+		{
+			name:   "json",
+			args:   []string{"-config", config, "-stats-file", statsFile, "-json"},
+			stdout: []string{`"top": [`, `"node": "g.z"`, `"hits": 2`, `"unused": [`, `"ak"`},
+		},
+		{
+			name:   "missing stats file",
+			args:   []string{"-config", config, "-stats-file", filepath.Join(t.TempDir(), "missing.json")},
+			stdout: []string{"  none recorded in", "Never used shortcuts:\n", "  ak\n", "  g\n"},
+		},
+		{
+			name:   "missing config",
+			args:   []string{"-config", filepath.Join(t.TempDir(), "missing.yml"), "-stats-file", statsFile},
+			code:   1,
+			stderr: []string{"Failed to load config:"},
+		},
+		{
+			name:   "missing stats file flag",
+			args:   []string{"-config", config},
+			code:   2,
+			stderr: []string{"-stats-file is required", "Usage: zap stats"},
+		},
+		{
+			name:   "unknown flag",
+			args:   []string{"-nope"},
+			code:   2,
+			stderr: []string{"flag provided but not defined: -nope", "Usage: zap stats"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runStats(tt.args, &stdout, &stderr); code != tt.code {
+				t.Errorf("exit code = %d, want %d\nstderr: %s", code, tt.code, stderr.String())
+			}
+			checkOutput(t, "stdout", stdout.String(), tt.stdout)
+			checkOutput(t, "stderr", stderr.String(), tt.stderr)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package zap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// AccessLog writes a structured log line per request.
+type AccessLog struct {
+	// Logger receives the log lines, see NewAccessLogger.
+	Logger *slog.Logger
+
+	// SampleRate is the fraction of requests logged, from 0 to 1. Server errors are
+	// always logged.
+	SampleRate float64
+
+	// TrustedProxies may set X-Forwarded-For. The client IP is the last address in the
+	// chain that is not a trusted proxy.
+	TrustedProxies []netip.Prefix
+}
+
+// NewAccessLogger returns a logger writing to w in the given format, "json" or "logfmt".
+func NewAccessLogger(w io.Writer, format string) (*slog.Logger, error) {
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, nil)), nil
+	case "logfmt", "text":
+		return slog.New(slog.NewTextHandler(w, nil)), nil
+	}
+	return nil, fmt.Errorf("unsupported access log format '%s', expected 'json' or 'logfmt'", format)
+}
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR ranges,
+// e.g. "127.0.0.1,10.0.0.0/8".
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.Contains(v, "/") {
+			p, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy range '%s': %w", v, err)
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address '%s': %w", v, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// accessEntryKey stores the *accessEntry of a request in its context.
+type accessEntryKey struct{}
+
+// accessEntry collects what handlers know about a request, for the access log.
+type accessEntry struct {
+	target string
+}
+
+// begin prepares r for logging. Returns nil if the request is not logged.
+func (a *AccessLog) begin(r *http.Request) (*accessEntry, *http.Request) {
+	if a == nil || a.Logger == nil {
+		return nil, r
+	}
+	e := &accessEntry{}
+	return e, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, e))
+}
+
+// setTarget records the URL a request was redirected or proxied to.
+func setTarget(r *http.Request, target string) {
+	if e, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		e.target = target
+	}
+}
+
+// log writes the access log line for a request, subject to sampling.
+func (a *AccessLog) log(e *accessEntry, r *http.Request, status int, latency time.Duration) {
+	if e == nil {
+		return
+	}
+	if status < http.StatusInternalServerError && a.SampleRate < 1 && rand.Float64() >= a.SampleRate {
+		return
+	}
+	a.Logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+		slog.String("host", requestHost(r)),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("url", e.target),
+		slog.Int("status", status),
+		slog.Duration("latency", latency),
+		slog.String("client_ip", a.clientIP(r)),
+		slog.String("user_agent", r.UserAgent()),
+	)
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only honored when the
+// request comes from a trusted proxy, and is read right to left up to the first address
+// that is not a trusted proxy itself. If the chain ends early, or holds something other
+// than an address, the last address that could be followed is returned.
+func (a *AccessLog) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !a.trusted(addr) {
+		return host
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		addr, err := netip.ParseAddr(hop)
+		if err != nil {
+			// Garbage in the chain, anything further left can't be trusted.
+			return host
+		}
+		host = addr.String()
+		if !a.trusted(addr) {
+			break
+		}
+	}
+	return host
+}
+
+// trusted reports whether addr is one of the trusted proxies.
+func (a *AccessLog) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range a.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package zap
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccessLog(t *testing.T) {
+	Convey("Given a handler with a JSON access log", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		var out bytes.Buffer
+		logger, err := NewAccessLogger(&out, "json")
+		So(err, ShouldBeNil)
+		proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+		So(err, ShouldBeNil)
+		al := &AccessLog{Logger: logger, SampleRate: 1, TrustedProxies: proxies}
+		handler := NewHandler(WithConfig(c), WithAccessLog(al))
+
+		serve := func(req *http.Request) map[string]interface{} {
+			out.Reset()
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			var line map[string]interface{}
+			So(json.Unmarshal(out.Bytes(), &line), ShouldBeNil)
+			return line
+		}
+
+		Convey("A redirect should be logged with its target", func() {
+			req := httptest.NewRequest("GET", "/s/foo", nil)
+			req.Host = "g"
+			req.RemoteAddr = "203.0.113.7:4242"
+			req.Header.Set("User-Agent", "curl/8.0")
+			line := serve(req)
+
+			So(line["msg"], ShouldEqual, "request")
+			So(line["host"], ShouldEqual, "g")
+			So(line["path"], ShouldEqual, "/s/foo")
+			So(line["url"], ShouldEqual, "https://github.com/search?q=foo")
+			So(line["status"], ShouldEqual, 302)
+			So(line["client_ip"], ShouldEqual, "203.0.113.7")
+			So(line["user_agent"], ShouldEqual, "curl/8.0")
+			So(line, ShouldContainKey, "latency")
+		})
+
+		Convey("A missing shortcut should be logged without a target", func() {
+			req := httptest.NewRequest("GET", "/x", nil)
+			req.Host = "nope"
+			line := serve(req)
+			So(line["status"], ShouldEqual, 404)
+			So(line["url"], ShouldEqual, "")
+		})
+
+		Convey("X-Forwarded-For should only be honored from trusted proxies", func() {
+			req := httptest.NewRequest("GET", "/z", nil)
+			req.Host = "g"
+			req.RemoteAddr = "10.1.2.3:80"
+			req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.9, 192.0.2.1")
+			So(serve(req)["client_ip"], ShouldEqual, "203.0.113.9")
+
+			req.RemoteAddr = "203.0.113.50:80"
+			So(serve(req)["client_ip"], ShouldEqual, "203.0.113.50")
+
+			req.RemoteAddr = "10.1.2.3:80"
+			req.Header.Set("X-Forwarded-For", "192.0.2.1")
+			So(serve(req)["client_ip"], ShouldEqual, "192.0.2.1")
+
+			// The chain can't be followed past garbage, so the closest known hop is reported.
+			req.Header.Set("X-Forwarded-For", "garbage, 192.0.2.1")
+			So(serve(req)["client_ip"], ShouldEqual, "192.0.2.1")
+		})
+
+		Convey("With sampling, only server errors should always be logged", func() {
+			al.SampleRate = 0
+			req := httptest.NewRequest("GET", "/z", nil)
+			req.Host = "g"
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			So(out.String(), ShouldBeEmpty)
+
+			broken := NewHandler(WithContext(NewContext(nil)), WithAccessLog(al))
+			broken.ServeHTTP(httptest.NewRecorder(), req)
+			So(out.String(), ShouldContainSubstring, `"status":500`)
+		})
+
+		Convey("With sampling, a proxied backend error should always be logged", func() {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			defer backend.Close()
+			pc, err := parseYamlString(proxyConfig(backend.URL))
+			So(err, ShouldBeNil)
+			al.SampleRate = 0
+			proxied := NewHandler(WithConfig(pc), WithAccessLog(al))
+
+			req := httptest.NewRequest("GET", "/some/path", nil)
+			req.Host = "p"
+			out.Reset()
+			rr := httptest.NewRecorder()
+			proxied.ServeHTTP(rr, req)
+			So(rr.Code, ShouldEqual, http.StatusBadGateway)
+			So(out.String(), ShouldContainSubstring, `"status":502`)
+			So(out.String(), ShouldContainSubstring, `"host":"p"`)
+		})
+	})
+
+	Convey("Given a logfmt access log", t, func() {
+		var out bytes.Buffer
+		logger, err := NewAccessLogger(&out, "logfmt")
+		So(err, ShouldBeNil)
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		handler := NewHandler(WithConfig(c), WithAccessLog(&AccessLog{Logger: logger, SampleRate: 1}))
+
+		req := httptest.NewRequest("GET", "/z", nil)
+		req.Host = "g"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		So(out.String(), ShouldContainSubstring, "msg=request host=g method=GET path=/z url=https://github.com/issmirnov/zap status=302")
+		So(strings.Count(out.String(), "\n"), ShouldEqual, 1)
+	})
+
+	Convey("Bad settings should be rejected", t, func() {
+		_, err := NewAccessLogger(&bytes.Buffer{}, "xml")
+		So(err, ShouldNotBeNil)
+		_, err = ParseTrustedProxies("10.0.0.0/33")
+		So(err, ShouldNotBeNil)
+		_, err = ParseTrustedProxies("localhost")
+		So(err, ShouldNotBeNil)
+	})
+}
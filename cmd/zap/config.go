@@ -1,61 +1,70 @@
 package zap
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"log"
-	"os"
+	"net/http"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/Jeffail/gabs/v2"
 	"github.com/fsnotify/fsnotify"
-	"github.com/ghodss/yaml"
 	"github.com/hashicorp/go-multierror"
 	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
 )
 
 const (
-	delimStart  = "### Zap Shortcuts :start ##\n"
-	delimEnd    = "### Zap Shortcuts :end ##\n"
-	expandKey   = "expand"
-	queryKey    = "query"
-	portKey     = "port"
-	passKey     = "*"
-	sslKey      = "ssl_off"
-	schemaKey   = "schema"
-	httpsPrefix = "https:/" // second slash appended in expandPath() call
-	httpPrefix  = "http:/"  // second slash appended in expandPath() call
+	delimStart   = "### Zap Shortcuts :start ##\n"
+	delimEnd     = "### Zap Shortcuts :end ##\n"
+	expandKey    = "expand"
+	queryKey     = "query"
+	querySepKey  = "query_sep"
+	queryRawKey  = "query_raw"
+	portKey      = "port"
+	passKey      = "*"
+	sslKey       = "ssl_off"
+	schemaKey    = "schema"
+	dropQueryKey = "drop_query"
+	statusKey    = "status"
+	proxyKey     = "proxy"
+	httpsPrefix  = "https:/" // second slash appended in expandPath() call
+	httpPrefix   = "http:/"  // second slash appended in expandPath() call
+
+	// defaultQuerySep keeps multi-token queries looking like paths, e.g. "q=foo/bar".
+	defaultQuerySep = "/"
 )
 
-// Sentinel value used to indicate set membership.
-var exists = struct{}{}
+// redirectStatuses are the values accepted for the 'status' key.
+var redirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusSeeOther:          true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
 
 // Afero is a filesystem wrapper providing util methods
 // and easy test mocks.
 var Afero = &afero.Afero{Fs: afero.NewOsFs()}
 
+// yamlLineErr extracts the line number from yaml.v3 syntax errors.
+var yamlLineErr = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
 // parseYamlString takes a raw string and attempts to load it.
-func parseYamlString(config string) (*gabs.Container, error) {
+func parseYamlString(config string) (*Node, error) {
 	if config == "" {
 		return nil, fmt.Errorf("empty configuration string provided")
 	}
-
-	d, jsonErr := yaml.YAMLToJSON([]byte(config))
-	if jsonErr != nil {
-		return nil, fmt.Errorf("failed to parse YAML configuration: %w", jsonErr)
-	}
-
-	j, err := gabs.ParseJSON(d)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse JSON configuration: %w", err)
-	}
-	return j, nil
+	return compileYaml("", []byte(config))
 }
 
-// ParseYaml takes a file name and returns a gabs Config object.
-func ParseYaml(fname string) (*gabs.Container, error) {
+// ParseYaml takes a file name and returns the compiled shortcut tree.
+// Syntax and type errors are reported one per line as "file:line:col: key.path: message".
+func ParseYaml(fname string) (*Node, error) {
 	if fname == "" {
 		return nil, fmt.Errorf("no configuration file specified")
 	}
@@ -68,173 +77,512 @@ func ParseYaml(fname string) (*gabs.Container, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("configuration file '%s' is empty", fname)
 	}
+	return compileYaml(fname, data)
+}
 
-	d, jsonErr := yaml.YAMLToJSON(data)
-	if jsonErr != nil {
-		return nil, fmt.Errorf("failed to parse YAML configuration in file '%s': %w", fname, jsonErr)
+// compileYaml decodes and compiles a config. fname is only used in error messages.
+func compileYaml(fname string, data []byte) (*Node, error) {
+	c := &compiler{file: fname}
+	root := c.decode(data)
+	if root == nil {
+		return nil, c.err()
 	}
+	n := c.compile(root, "", root.Line, root.Column, nil)
+	return n, c.err()
+}
 
-	j, err := gabs.ParseJSON(d)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse JSON configuration in file '%s': %w", fname, err)
+// maxNodes caps the size of a compiled config. Aliases can repeat a section many times
+// over, so a small file could otherwise expand to more shortcuts than fit in memory.
+const maxNodes = 100000
+
+// compiler collects positional errors while turning a YAML document into a Node tree.
+type compiler struct {
+	file   string
+	errors *multierror.Error
+
+	// active holds the mappings being compiled, from the root down, to catch aliases
+	// that refer to a mapping containing them.
+	active map[*yaml.Node]bool
+	nodes  int
+}
+
+// errorf records an error at the position of YAML node n, for the dotted key path.
+func (c *compiler) errorf(n *yaml.Node, path string, format string, args ...interface{}) {
+	c.errors = multierror.Append(c.errors, &ConfigError{
+		File:   c.file,
+		Line:   n.Line,
+		Column: n.Column,
+		Path:   path,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+// pos returns the position of YAML node n.
+func (c *compiler) pos(n *yaml.Node) position {
+	return position{file: c.file, line: n.Line, column: n.Column}
+}
+
+// err returns all recorded errors sorted by position, or nil.
+func (c *compiler) err() error {
+	if c.errors == nil {
+		return nil
 	}
-	return j, nil
+	sortConfigErrors(c.errors.Errors)
+	c.errors.ErrorFormat = listErrors
+	return c.errors
 }
 
-// ValidateConfig verifies that there are no unexpected values in the Config file.
-// at each level of the Config, we should either have a KV for expansions, or a leaf node
-// with the values oneof "expand", "query", "ssl_off" that map to a string.
-func ValidateConfig(c *gabs.Container) error {
-	if c == nil {
-		return fmt.Errorf("configuration is nil")
+// decode parses YAML into a node tree. Unlike decoding straight into maps, the node
+// tree keeps every key with its position, which lets us catch duplicated keys.
+func (c *compiler) decode(data []byte) *yaml.Node {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		e := &ConfigError{File: c.file, Msg: err.Error()}
+		if m := yamlLineErr.FindStringSubmatch(err.Error()); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = m[2]
+		}
+		c.errors = multierror.Append(c.errors, e)
+		return nil
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		c.errors = multierror.Append(c.errors, &ConfigError{File: c.file, Msg: "configuration contains no shortcuts"})
+		return nil
 	}
 
-	var errors *multierror.Error
-	children := c.ChildrenMap()
-	seenKeys := make(map[string]struct{})
+	root := resolveAlias(doc.Content[0])
+	if root.Kind != yaml.MappingNode {
+		c.errorf(root, "", "top level of configuration must be a mapping, got: %s", describe(root))
+		return nil
+	}
+	return root
+}
+
+// keyValue is a single entry of a YAML mapping. ref is the value as written, which is
+// the alias for values given as *name.
+type keyValue struct {
+	key, value, ref *yaml.Node
+}
+
+// entries flattens a mapping node into its entries, expanding "<<" merge keys.
+// Keys written out explicitly take precedence over merged ones. A key that is written
+// out twice is an error: YAML decoders silently keep only the last one, which hides
+// copy-paste mistakes in large configs. Pass a nil compiler to skip error reporting.
+//
+// merging holds the mappings whose entries are being collected, so that a mapping merging
+// itself, directly or through others, is only expanded once. It may be nil.
+func (c *compiler) entries(m *yaml.Node, path string, merging map[*yaml.Node]bool) []keyValue {
+	if merging == nil {
+		merging = make(map[*yaml.Node]bool)
+	}
+	merging[m] = true
+	defer delete(merging, m)
+
+	seen := make(map[string]*yaml.Node, len(m.Content)/2)
+	entries := make([]keyValue, 0, len(m.Content)/2)
+	var merged []keyValue
+
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		k, ref := m.Content[i], m.Content[i+1]
+		v := resolveAlias(ref)
+		if k.Kind != yaml.ScalarNode {
+			if c != nil {
+				c.errorf(k, path, "unsupported %s key, keys must be plain strings", describe(k))
+			}
+			continue
+		}
+		if k.ShortTag() == "!!merge" {
+			merged = append(merged, mergeEntries(v, merging)...)
+			continue
+		}
+		if first, ok := seen[k.Value]; ok {
+			if c != nil {
+				c.errorf(k, joinPath(path, k.Value), "duplicate key '%s', first defined on line %d", k.Value, first.Line)
+			}
+			continue
+		}
+		seen[k.Value] = k
+		entries = append(entries, keyValue{k, v, ref})
+	}
 
-	for k, v := range children {
-		// Check if key already seen
-		if _, ok := seenKeys[k]; ok {
-			errors = multierror.Append(errors, fmt.Errorf("duplicate key '%s' detected in configuration", k))
-		} else {
-			seenKeys[k] = exists // mark key as seen
+	for _, e := range merged {
+		if _, ok := seen[e.key.Value]; !ok {
+			seen[e.key.Value] = e.key
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// mergeEntries returns the entries pulled in by a "<<" merge key. Errors inside the merged
+// mappings are reported where they are defined, so they are not repeated here.
+func mergeEntries(v *yaml.Node, merging map[*yaml.Node]bool) []keyValue {
+	var sources []*yaml.Node
+	switch v.Kind {
+	case yaml.MappingNode:
+		sources = []*yaml.Node{v}
+	case yaml.SequenceNode:
+		sources = v.Content
+	}
+
+	var out []keyValue
+	var c *compiler
+	for _, src := range sources {
+		src = resolveAlias(src)
+		if src.Kind != yaml.MappingNode || merging[src] {
+			continue
+		}
+		out = append(out, c.entries(src, "", merging)...)
+	}
+	return out
+}
+
+// resolveAlias follows YAML aliases (*name) to the anchored node.
+func resolveAlias(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// joinPath appends a key to a dotted key path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// describe renders the type and value of a YAML node for error messages.
+func describe(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.SequenceNode:
+		return "sequence"
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!str":
+			return fmt.Sprintf("string (%s)", n.Value)
+		case "!!int", "!!float":
+			return fmt.Sprintf("number (%s)", n.Value)
+		case "!!bool":
+			return fmt.Sprintf("boolean (%s)", n.Value)
+		case "!!null":
+			return "null"
+		}
+		return fmt.Sprintf("%s (%s)", n.ShortTag(), n.Value)
+	}
+	return "unknown node"
+}
+
+// parseBool accepts YAML 1.2 booleans as well as the unquoted YAML 1.1 spellings
+// (yes/no/on/off) that older configs rely on, e.g. "ssl_off: yes".
+func parseBool(n *yaml.Node) (bool, bool) {
+	if n.Kind != yaml.ScalarNode {
+		return false, false
+	}
+	switch n.ShortTag() {
+	case "!!bool":
+		var b bool
+		return b, n.Decode(&b) == nil
+	case "!!str":
+		if n.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) != 0 {
+			return false, false
+		}
+		switch strings.ToLower(n.Value) {
+		case "y", "yes", "on":
+			return true, true
+		case "n", "no", "off":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// compile turns one YAML mapping into a Node, recursing into children.
+// At each level of the Config, we should either have a KV for expansions, or a leaf node
+// with the values oneof "expand", "query", "port", "schema", "ssl_off" of the expected type.
+// All errors are collected so that the user can fix them in one pass.
+// Settings that are inherited by descendants are resolved here from parent, so that
+// the request path only ever has to look at the deepest matched node.
+func (c *compiler) compile(m *yaml.Node, path string, line, column int, parent *Node) *Node {
+	n := &Node{
+		Children: make(map[string]*Node),
+		path:     path,
+		pos:      position{file: c.file, line: line, column: column},
+		valuePos: make(map[string]position),
+	}
+	if parent != nil {
+		n.dropQuery = parent.dropQuery
+		n.status = parent.status
+		n.proxy = parent.proxy
+	}
+	c.nodes++
+	if c.nodes > maxNodes {
+		if c.nodes == maxNodes+1 {
+			c.errorf(m, path, "configuration expands to more than %d sections, check the aliases", maxNodes)
+		}
+		return n
+	}
+	if c.active == nil {
+		c.active = make(map[*yaml.Node]bool)
+	}
+	c.active[m] = true
+	defer delete(c.active, m)
+
+	var hasExpand, hasQuery, hasPort bool
+	var querySep, queryRaw *yaml.Node
+
+	for _, e := range c.entries(m, path, nil) {
+		k, v := e.key.Value, e.value
+		keyPath := joinPath(path, k)
+		tag := v.ShortTag()
+		if v.Kind != yaml.ScalarNode {
+			tag = ""
 		}
 
-		// Validate all children
 		switch k {
 		case expandKey:
-			// check that v is a string or number, else return error.
-			if _, ok := v.Data().(string); !ok {
-				if _, ok := v.Data().(float64); !ok {
-					errors = multierror.Append(errors, fmt.Errorf("expected string or number value for 'expand' key, got: %T (%v)", v.Data(), v.Data()))
+			// expand takes a string or a number.
+			if tag == "!!str" || tag == "!!int" || tag == "!!float" {
+				n.Expand, hasExpand = v.Value, true
+				var err error
+				if n.template, n.args, err = parseTemplate(v.Value); err != nil {
+					c.errorf(v, keyPath, "%s", err)
 				}
+			} else {
+				c.errorf(v, keyPath, "expected string or number value for 'expand' key, got: %s", describe(v))
 			}
 		case queryKey:
-			// check that v is a string, else return error.
-			if _, ok := v.Data().(string); !ok {
-				errors = multierror.Append(errors, fmt.Errorf("expected string value for 'query' key, got: %T (%v)", v.Data(), v.Data()))
+			if tag == "!!str" {
+				n.Query, hasQuery = v.Value, true
+			} else {
+				c.errorf(v, keyPath, "expected string value for 'query' key, got: %s", describe(v))
+			}
+		case querySepKey:
+			if tag == "!!str" {
+				n.QuerySep, querySep = v.Value, v
+			} else {
+				c.errorf(v, keyPath, "expected string value for 'query_sep' key, got: %s", describe(v))
+			}
+		case queryRawKey:
+			if b, ok := parseBool(v); ok {
+				n.QueryRaw, queryRaw = b, v
+			} else {
+				c.errorf(v, keyPath, "expected boolean value for 'query_raw' key, got: %s", describe(v))
+			}
+		case dropQueryKey:
+			if b, ok := parseBool(v); ok {
+				n.DropQuery, n.dropQuery = &b, b
+			} else {
+				c.errorf(v, keyPath, "expected boolean value for 'drop_query' key, got: %s", describe(v))
+			}
+		case proxyKey:
+			if b, ok := parseBool(v); ok {
+				n.Proxy, n.proxy = &b, b
+			} else {
+				c.errorf(v, keyPath, "expected boolean value for 'proxy' key, got: %s", describe(v))
+			}
+		case statusKey:
+			var st int
+			if tag == "!!int" && v.Decode(&st) == nil {
+				n.Status, n.status = st, st
+				n.valuePos[statusKey] = c.pos(v)
+			} else {
+				c.errorf(v, keyPath, "expected number value for 'status' key, got: %s", describe(v))
 			}
 		case schemaKey:
-			// check that v is a string, else return error.
-			if _, ok := v.Data().(string); !ok {
-				errors = multierror.Append(errors, fmt.Errorf("expected string value for 'schema' key, got: %T (%v)", v.Data(), v.Data()))
+			if tag == "!!str" {
+				n.Schema = v.Value
+			} else {
+				c.errorf(v, keyPath, "expected string value for 'schema' key, got: %s", describe(v))
 			}
 		case portKey:
-			// check that v is a float64, else return error.
-			if _, ok := v.Data().(float64); !ok {
-				errors = multierror.Append(errors, fmt.Errorf("expected number value for 'port' key, got: %T (%v)", v.Data(), v.Data()))
+			var p int
+			if tag == "!!int" && v.Decode(&p) == nil {
+				n.Port, hasPort = p, true
+				n.valuePos[portKey] = c.pos(v)
+			} else {
+				c.errorf(v, keyPath, "expected number value for 'port' key, got: %s", describe(v))
 			}
 		case sslKey:
-			// check that v is a boolean, else return error.
-			if _, ok := v.Data().(bool); !ok {
-				errors = multierror.Append(errors, fmt.Errorf("expected boolean value for 'ssl_off' key, got: %T (%v)", v.Data(), v.Data()))
+			if b, ok := parseBool(v); ok {
+				n.SSLOff = b
+			} else {
+				c.errorf(v, keyPath, "expected boolean value for 'ssl_off' key, got: %s", describe(v))
 			}
 		default:
-			// Check if we have an unknown string here.
-			if _, ok := v.Data().(string); ok {
-				errors = multierror.Append(errors, fmt.Errorf("unexpected string value '%v' under key '%s' - this key is not recognized", v.Data(), k))
+			if v.Kind != yaml.MappingNode {
+				if tag == "!!str" {
+					c.errorf(v, keyPath, "unexpected string value '%v' under key '%s' - this key is not recognized", v.Value, k)
+				} else {
+					c.errorf(v, keyPath, "unexpected %s under key '%s' - expected a nested section", describe(v), k)
+				}
+				continue
+			}
+			if c.active[v] {
+				if e.ref.Kind == yaml.AliasNode {
+					c.errorf(e.ref, keyPath, "alias *%s refers to a section that contains it", e.ref.Value)
+				} else {
+					c.errorf(e.ref, keyPath, "a merge key brings in a section that contains it")
+				}
+				continue
 			}
-			// recurse, collect any errors.
-			if err := ValidateConfig(v); err != nil {
-				errors = multierror.Append(errors, fmt.Errorf("validation error in section '%s': %w", k, err))
+			// recurse, errors are collected by the compiler.
+			child := c.compile(v, keyPath, e.key.Line, e.key.Column, n)
+			if k == passKey {
+				n.Wildcard = child
+			} else {
+				n.Children[k] = child
 			}
 		}
 	}
-	return errors.ErrorOrNil()
+
+	// Query options only make sense next to the query they configure.
+	if !hasQuery {
+		for key, v := range map[string]*yaml.Node{querySepKey: querySep, queryRawKey: queryRaw} {
+			if v != nil {
+				c.errorf(v, joinPath(path, key), "'%s' is only valid alongside 'query'", key)
+			}
+		}
+	} else if querySep == nil {
+		n.QuerySep = defaultQuerySep
+	}
+
+	// Precedence mirrors the original lookup order: expand, then query, then port.
+	switch {
+	case hasExpand:
+		n.action, n.prefix = expand, n.Expand
+	case hasQuery:
+		n.action, n.prefix = query, n.Query
+	case hasPort:
+		n.action, n.prefix = port, ":"+strconv.Itoa(n.Port)
+	}
+	return n
+}
+
+// ValidateConfig performs semantic checks on a compiled Config that go beyond
+// the type checks done while parsing.
+func ValidateConfig(c *Node) error {
+	if c == nil {
+		return fmt.Errorf("configuration is nil")
+	}
+
+	var errors *multierror.Error
+	validateNode(c, &errors)
+	if errors == nil {
+		return nil
+	}
+	sortConfigErrors(errors.Errors)
+	errors.ErrorFormat = listErrors
+	return errors
+}
+
+func validateNode(n *Node, errors **multierror.Error) {
+	if n.action == port && (n.Port < 1 || n.Port > 65535) {
+		*errors = multierror.Append(*errors, n.errorf(portKey, "port %d is out of range, expected 1-65535", n.Port))
+	}
+	if n.Status != 0 && !redirectStatuses[n.Status] {
+		*errors = multierror.Append(*errors, n.errorf(statusKey, "unsupported redirect status %d, expected one of 301, 302, 303, 307, 308", n.Status))
+	}
+
+	for _, child := range n.Children {
+		validateNode(child, errors)
+	}
+	if n.Wildcard != nil {
+		validateNode(n.Wildcard, errors)
+	}
+}
+
+// listErrors formats a multierror one error per line, so that editors can parse the output.
+func listErrors(es []error) string {
+	lines := make([]string, len(es))
+	for i, e := range es {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sortConfigErrors orders positional errors by file position. Other errors keep their place.
+func sortConfigErrors(es []error) {
+	sort.SliceStable(es, func(i, j int) bool {
+		a, oka := es[i].(*ConfigError)
+		b, okb := es[j].(*ConfigError)
+		if !oka || !okb {
+			return false
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
 }
 
 // WatchConfigFileChanges will attach an fsnotify watcher to the config file, and trigger
-// the cb function when the file is updated.
-func WatchConfigFileChanges(watcher *fsnotify.Watcher, fname string, cb func()) {
+// the cb function when the file is updated. It returns when ctx is done or the watcher
+// is closed.
+func WatchConfigFileChanges(ctx context.Context, watcher *fsnotify.Watcher, fname string, cb func()) {
 	for {
 		select {
-		case event := <-watcher.Events:
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
 			// You may wonder why we can't just listen for "Write" events. The reason is that vim (and other editors)
 			// will create swap files, and when you write they delete the original and rename the swap file. This is great
 			// for resolving system crashes, but also completely incompatible with inotify and other fswatch implementations.
 			// Thus, we check that the file of interest might be created as well.
 			updated := event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Write == fsnotify.Write
-			zapconf := filepath.Clean(event.Name) == fname
+			zapconf := filepath.Clean(event.Name) == filepath.Clean(fname)
 			if updated && zapconf {
 				log.Printf("Configuration file '%s' changed, reloading...", fname)
 				cb()
-				log.Printf("Configuration reloaded successfully")
 			}
-		case e := <-watcher.Errors:
+		case e, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
 			log.Printf("File watcher error: %v", e)
 		}
 	}
 }
 
-// TODO: add tests. simulate touching a file.
-// UpdateHosts will attempt to write the zap list of shortcuts
-// to /etc/hosts. It will gracefully fail if there are not enough
-// permissions to do so. Can be disabled via ZAP_DISABLE_HOSTS_UPDATE env var.
-func UpdateHosts(c *Context) error {
-	// Check if hosts file updates are disabled (useful for containerized environments)
-	if os.Getenv("ZAP_DISABLE_HOSTS_UPDATE") != "" {
-		log.Println("Hosts file updates disabled via ZAP_DISABLE_HOSTS_UPDATE environment variable")
-		return nil
-	}
+// Reload reads and validates configName, then swaps it in and syncs the hosts file.
+// If the new config is invalid the current one is kept, and the error is returned.
+// Concurrent reloads are applied one at a time.
+func Reload(c *Context, configName string) error {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
 
-	hostPath := "/etc/hosts"
-
-	// 1. read file, prep buffer.
-	data, err := os.ReadFile(hostPath)
-	if err != nil {
-		return fmt.Errorf("failed to read hosts file '%s': %w", hostPath, err)
+	data, err := ParseYaml(configName)
+	if err == nil {
+		err = ValidateConfig(data)
 	}
-	var replacement bytes.Buffer
-
-	// 2. generate payload.
-	replacement.WriteString(delimStart)
-	children := c.Config.ChildrenMap()
-	for k := range children {
-		replacement.WriteString(fmt.Sprintf("%s %s\n", c.Advertise, k))
+	c.metrics.reloaded(err)
+	if err != nil {
+		return err
 	}
-	replacement.WriteString(delimEnd)
 
-	// 3. Generate new file content
-	var updatedFile string
-	if !strings.Contains(string(data), delimStart) {
-		updatedFile = string(data) + replacement.String()
-	} else {
-		zapBlock := regexp.MustCompile("(###(.*)##)\n(.|\n)*(###(.*)##\n)")
-		updatedFile = zapBlock.ReplaceAllString(string(data), replacement.String())
-	}
+	// Update Config atomically. In-flight requests keep their old snapshot.
+	c.SetConfig(data)
 
-	// 4. Attempt write to file.
-	err = os.WriteFile(hostPath, []byte(updatedFile), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write to hosts file '%s': %w", hostPath, err)
+	// Sync DNS entries.
+	if err := UpdateHosts(c); err != nil {
+		c.logf("Warning: Failed to update hosts file during reload: %v", err)
 	}
-
 	return nil
 }
 
 // MakeReloadCallback returns a func that that reads the config file and updates global state.
 func MakeReloadCallback(c *Context, configName string) func() {
 	return func() {
-		data, err := ParseYaml(configName)
-		if err != nil {
-			log.Printf("Error loading new Config: %s. Fallback to old Config.", err)
-			return
-		}
-		err = ValidateConfig(data)
-		if err != nil {
-			log.Printf("Error validating new Config: %s. Fallback to old Config.", err)
+		if err := Reload(c, configName); err != nil {
+			c.logf("Error loading new Config: %s. Fallback to old Config.", err)
 			return
 		}
-
-		// Update Config atomically
-		c.ConfigMtx.Lock()
-		c.Config = data
-		c.ConfigMtx.Unlock()
-
-		// Sync DNS entries.
-		if err := UpdateHosts(c); err != nil {
-			log.Printf("Warning: Failed to update hosts file during reload: %v", err)
-		}
+		c.logf("Configuration reloaded successfully")
 	}
 }
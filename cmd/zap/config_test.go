@@ -1,10 +1,22 @@
 package zap
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/Jeffail/gabs/v2"
-
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/go-multierror"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/spf13/afero"
 )
@@ -20,6 +32,20 @@ g:
     expand: issmirnov/dotfiles
 `
 
+const duplicatedYAML = `
+g:
+  expand: github.com
+  z:
+    expand: issmirnov/zap
+  z:
+    expand: issmirnov/zap-dup
+l:
+  expand: localhost
+l:
+  expand: localhost
+  ssl_off: yes
+`
+
 const badValuesYAML = `
 e:
   expand: 2
@@ -35,8 +61,10 @@ l:
 const cYaml = `
 e:
   expand: example.com
+  status: 301
   a:
     expand: apples
+    status: 307
   b:
     expand: bananas
 g:
@@ -45,6 +73,14 @@ g:
     expand: issmirnov/dotfiles
   z:
     expand: issmirnov/zap
+  pr:
+    expand: "issmirnov/{1}/pull/{2}"
+  sp:
+    query: "search?q="
+    query_sep: "+"
+  sr:
+    query: "search?q="
+    query_raw: yes
   s:
     query: "search?q="
     me:
@@ -70,13 +106,21 @@ l:
       expand: service
 ak:
   expand: kafka.apache.org
+  drop_query: yes
   hi:
     expand: contact
+  q:
+    expand: quickstart
+    drop_query: no
   "*":
     d:
       expand: documentation.html
     j:
       expand: javadoc/index.html?overview-summary.html
+j:
+  expand: "jira.corp/browse/PROJ-{1}"
+gp:
+  expand: "github.com/{1}/{2}/pulls"
 wc:
   expand: wildcard.com
   "*":
@@ -95,21 +139,38 @@ ch:
   schema: chrome 
 `
 
-func loadTestYaml() (*gabs.Container, error) {
+func loadTestYaml() (*Node, error) {
 	return parseYamlString(cYaml)
 }
 
+// useMemFs swaps Afero for an empty in-memory filesystem until the test ends.
+func useMemFs(t *testing.T) {
+	t.Helper()
+	old := Afero
+	Afero = &afero.Afero{Fs: afero.NewMemMapFs()}
+	t.Cleanup(func() { Afero = old })
+}
+
 func TestParseYaml(t *testing.T) {
 	Convey("Given a valid 'c.yml' file", t, func() {
-		Afero = &afero.Afero{Fs: afero.NewMemMapFs()}
+		useMemFs(t)
 		err := Afero.WriteFile("c.yml", []byte(cYaml), 0644)
 		So(err, ShouldBeNil)
 		c, err := ParseYaml("c.yml")
 		Convey("ParseYaml should throw no error", func() {
 			So(err, ShouldBeNil)
 		})
-		Convey("the gabs object should have path 'zz' present", func() {
-			So(c.ExistsP("zz"), ShouldBeTrue)
+		Convey("the config should have path 'zz' present", func() {
+			So(c.Children, ShouldContainKey, "zz")
+		})
+		Convey("reserved keys should be compiled into typed fields", func() {
+			So(c.Children["zz"].Expand, ShouldEqual, "zero.ssl.on.com")
+			So(c.Children["z"].SSLOff, ShouldBeTrue)
+			So(c.Children["l"].Children["a"].Port, ShouldEqual, 8080)
+			So(c.Children["g"].Children["s"].Query, ShouldEqual, "search?q=")
+			So(c.Children["ch"].Schema, ShouldEqual, "chrome")
+			So(c.Children["ak"].Wildcard, ShouldNotBeNil)
+			So(c.Children["ak"].Children, ShouldNotContainKey, "*")
 		})
 	})
 }
@@ -123,31 +184,261 @@ func TestValidateConfig(t *testing.T) {
 		})
 	})
 
-	// The YAML libraries don't have support for detecting duplicate keys
-	// at parse time. Users will have to figure this out themselves.
-	//Convey("Given a yaml Config with duplicated keys", t, func() {
-	//	conf, _ := parseYamlString(duplicatedYAML)
-	//	Convey("The validator should complain", func() {
-	//		So(ValidateConfig(conf), ShouldNotBeNil)
-	//	})
-	//})
+	Convey("Given a yaml Config with duplicated keys", t, func() {
+		_, err := parseYamlString(duplicatedYAML)
+		Convey("The parser should report every duplicate with its line numbers", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "10:1: l: duplicate key 'l', first defined on line 8")
+			So(err.Error(), ShouldContainSubstring, "6:3: g.z: duplicate key 'z', first defined on line 4")
+		})
+	})
+
+	Convey("Given a yaml Config using anchors and merge keys", t, func() {
+		conf, err := parseYamlString(`
+base: &base
+  expand: github.com
+  z:
+    expand: issmirnov/zap
+gh:
+  <<: *base
+  z:
+    expand: issmirnov/zap-override
+`)
+		Convey("Merged keys should not count as duplicates", func() {
+			So(err, ShouldBeNil)
+			So(conf.Children["gh"].Expand, ShouldEqual, "github.com")
+			So(conf.Children["gh"].Children["z"].Expand, ShouldEqual, "issmirnov/zap-override")
+		})
+	})
+
+	Convey("Given a yaml Config with self-referencing anchors", t, func() {
+		Convey("An alias to a containing section should be an error at the alias", func() {
+			_, err := parseYamlString("g: &a {expand: x, h: *a}\n")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "1:22: g.h: alias *a refers to a section that contains it")
+		})
+
+		Convey("A merge bringing in a containing section should be an error", func() {
+			_, err := parseYamlString("g: &a\n  expand: x\n  h:\n    <<: *a\n")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "4:5: g.h.h: a merge key brings in a section that contains it")
+		})
+
+		Convey("A section merging itself should be expanded once", func() {
+			conf, err := parseYamlString("g: &a\n  expand: x\n  <<: *a\n")
+			So(err, ShouldBeNil)
+			So(conf.Children["g"].Expand, ShouldEqual, "x")
+		})
+
+		Convey("Aliases repeating sections exponentially should be capped", func() {
+			var b strings.Builder
+			b.WriteString("l0: &l0 {expand: x}\n")
+			for i := 1; i <= 20; i++ {
+				fmt.Fprintf(&b, "l%d: &l%d {a: *l%d, b: *l%d}\n", i, i, i-1, i-1)
+			}
+			_, err := parseYamlString(b.String())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "configuration expands to more than 100000 sections")
+			So(strings.Count(err.Error(), "\n"), ShouldEqual, 0)
+		})
+	})
 
 	Convey("Given a YAML Config with unknown keys", t, func() {
-		conf, _ := parseYamlString(badkeysYAML)
-		Convey("The validator should raise an error", func() {
-			So(ValidateConfig(conf), ShouldNotBeNil)
+		_, err := parseYamlString(badkeysYAML)
+		Convey("The parser should raise an error", func() {
+			So(err, ShouldNotBeNil)
 		})
 	})
 
 	Convey("Given a YAML Config with malformed values", t, func() {
-		conf, _ := parseYamlString(badValuesYAML)
-		err := ValidateConfig(conf)
-		Convey("The validator should raise errors for invalid values", func() {
+		_, err := parseYamlString(badValuesYAML)
+		Convey("The parser should raise errors for invalid values", func() {
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "expected number value for 'port' key, got: string (not_int)")
-			So(err.Error(), ShouldContainSubstring, "expected string value for 'query' key, got: float64 (3)")
+			So(err.Error(), ShouldContainSubstring, "expected string value for 'query' key, got: number (3)")
 			So(err.Error(), ShouldContainSubstring, "expected boolean value for 'ssl_off' key, got: string (not_bool)")
 			// Note: expand: 2 is now valid since we allow both strings and numbers for expand keys
 		})
 	})
+
+	Convey("Given a YAML Config with query options but no query", t, func() {
+		_, err := parseYamlString("g:\n  expand: github.com\n  query_sep: \"+\"\n")
+		Convey("The parser should raise an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "3:14: g.query_sep: 'query_sep' is only valid alongside 'query'")
+		})
+	})
+
+	Convey("Given a YAML Config with a zero placeholder", t, func() {
+		_, err := parseYamlString("j:\n  expand: \"jira.corp/browse/{0}\"\n")
+		Convey("The parser should raise an error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "2:11: j.expand: invalid placeholder '{0}'")
+		})
+	})
+
+	Convey("Given a YAML Config with an unsupported redirect status", t, func() {
+		conf, err := parseYamlString("g:\n  expand: github.com\n  z:\n    expand: issmirnov/zap\n    status: 200\n")
+		So(err, ShouldBeNil)
+		Convey("The validator should raise an error", func() {
+			err := ValidateConfig(conf)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "5:13: g.z.status: unsupported redirect status 200")
+		})
+	})
+
+	Convey("Given a YAML Config with an out of range port", t, func() {
+		conf, err := parseYamlString("l:\n  expand: localhost\n  a:\n    port: 70000\n")
+		So(err, ShouldBeNil)
+		Convey("The validator should raise an error", func() {
+			err := ValidateConfig(conf)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "4:11: l.a.port: port 70000 is out of range")
+		})
+	})
+
+	Convey("Given a config file with malformed values", t, func() {
+		useMemFs(t)
+		So(Afero.WriteFile("bad.yml", []byte(badValuesYAML), 0644), ShouldBeNil)
+		_, err := ParseYaml("bad.yml")
+		Convey("Every error should be on its own line as file:line:col: key.path: message", func() {
+			So(err, ShouldNotBeNil)
+			lines := strings.Split(err.Error(), "\n")
+			So(lines, ShouldResemble, []string{
+				"bad.yml:5:12: e.s.query: expected string value for 'query' key, got: number (3)",
+				"bad.yml:8:12: g.ssl_off: expected boolean value for 'ssl_off' key, got: string (not_bool)",
+				"bad.yml:10:9: l.port: expected number value for 'port' key, got: string (not_int)",
+			})
+		})
+		Convey("The errors should carry their position", func() {
+			var merr *multierror.Error
+			So(errors.As(err, &merr), ShouldBeTrue)
+			var cerr *ConfigError
+			So(errors.As(merr.Errors[0], &cerr), ShouldBeTrue)
+			So(cerr.File, ShouldEqual, "bad.yml")
+			So(cerr.Line, ShouldEqual, 5)
+			So(cerr.Column, ShouldEqual, 12)
+			So(cerr.Path, ShouldEqual, "e.s.query")
+		})
+	})
+
+	Convey("Given a config file with a syntax error", t, func() {
+		useMemFs(t)
+		So(Afero.WriteFile("bad.yml", []byte("a:\n  b: [\n"), 0644), ShouldBeNil)
+		_, err := ParseYaml("bad.yml")
+		Convey("The error should carry the line number", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "bad.yml:2: ")
+		})
+	})
+}
+
+// TestReloadRace hammers IndexHandler while the config is being swapped underneath it.
+// Run with -race to verify that reloads and requests never touch the same memory unsynchronized.
+func TestReloadRace(t *testing.T) {
+	t.Setenv("ZAP_DISABLE_HOSTS_UPDATE", "1")
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	useMemFs(t)
+	alt := strings.Replace(cYaml, "expand: issmirnov/zap", "expand: issmirnov/zap-alt", 1)
+	if err := Afero.WriteFile("c.yml", []byte(cYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ParseYaml("c.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewContext(c)
+	handler := CtxWrapper{Context: ctx, H: IndexHandler}
+	reload := MakeReloadCallback(ctx, "c.yml")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				req := httptest.NewRequest("GET", "/z", nil)
+				req.Host = "g"
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				loc := rr.Header().Get("Location")
+				if rr.Code != http.StatusFound || (loc != "https://github.com/issmirnov/zap" && loc != "https://github.com/issmirnov/zap-alt") {
+					t.Errorf("unexpected response during reload: %d %q", rr.Code, loc)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		data := cYaml
+		if i%2 == 0 {
+			data = alt
+		}
+		if err := Afero.WriteFile("c.yml", []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		reload()
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestWatchConfigFileChanges(t *testing.T) {
+	Convey("Given a watcher on the config directory", t, func() {
+		dir := t.TempDir()
+		fname := filepath.Join(dir, "c.yml")
+		So(os.WriteFile(fname, []byte(cYaml), 0644), ShouldBeNil)
+
+		watcher, err := fsnotify.NewWatcher()
+		So(err, ShouldBeNil)
+		defer func() { _ = watcher.Close() }()
+		So(watcher.Add(dir), ShouldBeNil)
+
+		reloads := make(chan struct{}, 10)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			WatchConfigFileChanges(ctx, watcher, fname, func() { reloads <- struct{}{} })
+			close(done)
+		}()
+
+		Convey("Writing the config file should trigger a reload", func() {
+			So(os.WriteFile(filepath.Join(dir, "other.yml"), []byte("x"), 0644), ShouldBeNil)
+			So(os.WriteFile(fname, []byte(cYaml), 0644), ShouldBeNil)
+			select {
+			case <-reloads:
+			case <-time.After(5 * time.Second):
+				So("no reload", ShouldBeEmpty)
+			}
+		})
+
+		Convey("It should return once the context is canceled", func() {
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				So("still watching", ShouldBeEmpty)
+			}
+		})
+
+		Convey("It should return once the watcher is closed", func() {
+			So(watcher.Close(), ShouldBeNil)
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				So("still watching", ShouldBeEmpty)
+			}
+		})
+	})
 }
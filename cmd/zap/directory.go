@@ -0,0 +1,124 @@
+package zap
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DirectoryPath is the reserved path that serves the directory of shortcuts on any host,
+// unless moved with WithAdminPrefix.
+const DirectoryPath = DefaultAdminPrefix + "/"
+
+// directoryEntry is one row of the shortcut directory.
+type directoryEntry struct {
+	// Shortcut is what the user types, e.g. "g/s".
+	Shortcut string
+
+	// Target is the URL the shortcut expands to.
+	Target string
+
+	// Notes are annotations such as "ssl off" or "port 8080".
+	Notes []string
+
+	// Depth is the nesting level, used for indentation.
+	Depth int
+}
+
+// DirectoryHandler serves an HTML page listing every shortcut with its target.
+func DirectoryHandler(ctx *Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	conf := ctx.Config()
+	if conf == nil {
+		return http.StatusInternalServerError, fmt.Errorf("configuration not loaded or invalid")
+	}
+	return directory(conf, w)
+}
+
+func directory(conf *Node, w http.ResponseWriter) (int, error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := directoryPage.Execute(w, directoryEntries(conf)); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to render directory: %w", err)
+	}
+	return http.StatusOK, nil
+}
+
+// isSelfHost reports whether a request for host is addressed to zap itself rather than
+// to a shortcut: an IP literal, such as an advertised address, or localhost.
+func isSelfHost(ctx *Context, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return host == "localhost" || host == "zap" || net.ParseIP(host) != nil
+}
+
+// directoryEntries flattens the config into rows, depth first with keys in lexical
+// order and wildcards last.
+func directoryEntries(conf *Node) []directoryEntry {
+	var entries []directoryEntry
+	for _, k := range sortedKeys(conf.Children) {
+		entries = appendEntries(entries, conf, conf.Children[k], []string{k})
+	}
+	return entries
+}
+
+func appendEntries(entries []directoryEntry, conf, n *Node, tokens []string) []directoryEntry {
+	shortcut := strings.Join(tokens, "/")
+
+	// Show placeholders as-is, rather than expanding them to empty strings.
+	path := ""
+	if len(tokens) > 1 {
+		path = "/" + strings.Join(tokens[1:], "/")
+	}
+	for i := 1; i <= n.args; i++ {
+		path += fmt.Sprintf("/{%d}", i)
+	}
+
+	entry := directoryEntry{Shortcut: shortcut, Depth: len(tokens) - 1, Notes: n.notes(len(tokens) == 1)}
+	if res, err := NewResolver(conf).Resolve(tokens[0], path, ""); err == nil {
+		entry.Target = res.URL
+	} else {
+		entry.Target = err.Error()
+	}
+	entries = append(entries, entry)
+
+	for _, k := range sortedKeys(n.Children) {
+		entries = appendEntries(entries, conf, n.Children[k], append(tokens[:len(tokens):len(tokens)], k))
+	}
+	if n.Wildcard != nil {
+		entries = appendEntries(entries, conf, n.Wildcard, append(tokens[:len(tokens):len(tokens)], passKey))
+	}
+	return entries
+}
+
+// notes lists the settings of a node worth pointing out in the directory.
+func (n *Node) notes(topLevel bool) []string {
+	var notes []string
+	if topLevel && n.Schema != "" {
+		notes = append(notes, "schema "+n.Schema)
+	}
+	if topLevel && n.SSLOff {
+		notes = append(notes, "ssl off")
+	}
+	switch n.action {
+	case query:
+		notes = append(notes, "query")
+	case port:
+		notes = append(notes, fmt.Sprintf("port %d", n.Port))
+	}
+	if n.args > 0 {
+		notes = append(notes, fmt.Sprintf("%d parameters", n.args))
+	}
+	if n.Status != 0 {
+		notes = append(notes, fmt.Sprintf("status %d", n.Status))
+	}
+	if n.Proxy != nil && *n.Proxy {
+		notes = append(notes, "proxy")
+	}
+	if n.DropQuery != nil && *n.DropQuery {
+		notes = append(notes, "drops query")
+	}
+	return notes
+}
@@ -0,0 +1,88 @@
+package zap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDirectoryEntries(t *testing.T) {
+	Convey("Given the default Config", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		entries := directoryEntries(c)
+
+		find := func(shortcut string) *directoryEntry {
+			for i := range entries {
+				if entries[i].Shortcut == shortcut {
+					return &entries[i]
+				}
+			}
+			return nil
+		}
+
+		Convey("Every node should be listed with its expanded target", func() {
+			So(find("g/z").Target, ShouldEqual, "https://github.com/issmirnov/zap")
+			So(find("g/s").Target, ShouldEqual, "https://github.com/search?q=")
+			So(find("l/a/s").Target, ShouldEqual, "http://localhost:8080/service")
+			So(find("ch/n/d").Target, ShouldEqual, "chrome://net-internals/#dns")
+			So(find("ak/*/j").Target, ShouldEqual, "https://kafka.apache.org/*/javadoc/index.html?overview-summary.html")
+		})
+
+		Convey("Placeholders should be shown rather than filled in", func() {
+			So(find("gp").Target, ShouldEqual, "https://github.com/{1}/{2}/pulls")
+		})
+
+		Convey("Entries should carry annotations and depth", func() {
+			So(find("z").Notes, ShouldContain, "ssl off")
+			So(find("ch").Notes, ShouldContain, "schema chrome")
+			So(find("l/a").Notes, ShouldContain, "port 8080")
+			So(find("g/s").Notes, ShouldContain, "query")
+			So(find("l/a/s").Depth, ShouldEqual, 2)
+		})
+
+		Convey("Entries should be in tree order", func() {
+			So(entries[0].Shortcut, ShouldEqual, "ak")
+			So(entries[1].Shortcut, ShouldEqual, "ak/hi")
+		})
+	})
+}
+
+func TestDirectoryHandler(t *testing.T) {
+	Convey("Given app is set up with default Config", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		context := NewContext(c)
+		context.Advertise = "10.0.0.1"
+
+		Convey("When we GET /_zap/", func() {
+			req := httptest.NewRequest("GET", DirectoryPath, nil)
+			req.Host = "g"
+			rr := httptest.NewRecorder()
+			http.Handler(&CtxWrapper{context, DirectoryHandler}).ServeHTTP(rr, req)
+
+			Convey("We should get the HTML directory", func() {
+				So(rr.Code, ShouldEqual, http.StatusOK)
+				So(rr.Header().Get("Content-Type"), ShouldStartWith, "text/html")
+				So(rr.Body.String(), ShouldContainSubstring, "https://github.com/issmirnov/zap")
+				So(rr.Body.String(), ShouldContainSubstring, `id="filter"`)
+			})
+		})
+
+		for _, host := range []string{"localhost:8927", "127.0.0.1", "[::1]:8927", "10.0.0.1"} {
+			Convey("When we GET / on zap's own address "+host, func() {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Host = host
+				rr := httptest.NewRecorder()
+				http.Handler(&CtxWrapper{context, IndexHandler}).ServeHTTP(rr, req)
+
+				Convey("We should get the directory instead of a 404", func() {
+					So(rr.Code, ShouldEqual, http.StatusOK)
+					So(rr.Body.String(), ShouldContainSubstring, "<h1>Shortcuts</h1>")
+				})
+			})
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package zap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsTTL is the TTL of answers for shortcut names. It is short, since a reload may remove them.
+const dnsTTL = 60
+
+// DNSServer answers A and AAAA queries for every top-level shortcut with the advertised
+// addresses, so that a whole network can use the shortcuts by pointing a resolver at zap.
+// Queries for other names are forwarded to Upstream, or refused if it is empty.
+type DNSServer struct {
+	ctx *Context
+
+	// Upstream is the "host:port" of the resolver other queries are forwarded to.
+	Upstream string
+
+	// Timeout bounds queries to Upstream.
+	Timeout time.Duration
+}
+
+// NewDNSServer returns a DNS server for the shortcuts of ctx.
+func NewDNSServer(ctx *Context, upstream string) *DNSServer {
+	return &DNSServer{ctx: ctx, Upstream: upstream, Timeout: 5 * time.Second}
+}
+
+// ListenAndServe serves DNS over UDP and TCP on addr, e.g. ":5353", until ctx is done.
+func (s *DNSServer) ListenAndServe(ctx context.Context, addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for DNS on udp %s: %w", addr, err)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		_ = pc.Close()
+		return fmt.Errorf("failed to listen for DNS on tcp %s: %w", addr, err)
+	}
+	return s.Serve(ctx, pc, l)
+}
+
+// Serve answers queries arriving on pc and, if not nil, l until ctx is done.
+func (s *DNSServer) Serve(ctx context.Context, pc net.PacketConn, l net.Listener) error {
+	servers := []*dns.Server{{PacketConn: pc, Handler: s}}
+	if l != nil {
+		servers = append(servers, &dns.Server{Listener: l, Handler: s})
+	}
+
+	// Wait for every server to start, Shutdown fails on servers that haven't.
+	errc := make(chan error, len(servers))
+	started := make(chan struct{}, len(servers))
+	for _, srv := range servers {
+		srv.NotifyStartedFunc = func() { started <- struct{}{} }
+		go func() { errc <- srv.ActivateAndServe() }()
+	}
+	for range servers {
+		select {
+		case <-started:
+		case err := <-errc:
+			return fmt.Errorf("failed to start DNS server: %w", err)
+		}
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+	}
+	for _, srv := range servers {
+		if serr := srv.Shutdown(); serr != nil && err == nil && ctx.Err() == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// ServeDNS implements dns.Handler.
+func (s *DNSServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := s.answer(req)
+	if resp == nil {
+		// Use the client's transport, so that truncated answers can be retried over TCP.
+		network := "udp"
+		if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+			network = "tcp"
+		}
+		resp = s.forward(req, network)
+	}
+	if err := w.WriteMsg(resp); err != nil {
+		s.ctx.logf("Failed to write DNS response: %v", err)
+	}
+}
+
+// answer returns the response for a query about a shortcut, or nil if the query is
+// about something else.
+func (s *DNSServer) answer(req *dns.Msg) *dns.Msg {
+	if req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
+		return nil
+	}
+	q := req.Question[0]
+	if q.Qclass != dns.ClassINET || !s.isShortcut(q.Name) {
+		return nil
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	for _, a := range s.ctx.advertised() {
+		rr := addrRR(q.Name, a, dnsTTL)
+		if q.Qtype == rr.Header().Rrtype || q.Qtype == dns.TypeANY {
+			resp.Answer = append(resp.Answer, rr)
+		}
+	}
+	// Other types get an empty answer: the name exists, but has no such records.
+	return resp
+}
+
+// addrRR returns an A or AAAA record, depending on the family of a, pointing name at a.
+func addrRR(name string, a netip.Addr, ttl uint32) dns.RR {
+	hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: ttl}
+	if a.Is4() {
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: a.AsSlice()}
+	}
+	hdr.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: hdr, AAAA: a.AsSlice()}
+}
+
+// isShortcut reports whether name, e.g. "g.", is a top-level shortcut. DNS names are
+// case insensitive, so "G." matches too. Keys that aren't valid host names are left to
+// the upstream resolver, like ExportDNS leaves them out.
+func (s *DNSServer) isShortcut(name string) bool {
+	conf := s.ctx.Config()
+	if conf == nil {
+		return false
+	}
+	key, _, ok := conf.lookupHost(strings.TrimSuffix(name, "."))
+	if ok && !isHostName(key) {
+		s.ctx.logf("Not answering DNS query for shortcut '%s', it is not a valid host name", key)
+		return false
+	}
+	return ok
+}
+
+// forward passes a query on to the upstream resolver, or refuses it if there is none.
+func (s *DNSServer) forward(req *dns.Msg, network string) *dns.Msg {
+	if s.Upstream != "" {
+		c := &dns.Client{Net: network, Timeout: s.Timeout}
+		resp, _, err := c.Exchange(req, s.Upstream)
+		if err == nil {
+			return resp
+		}
+		s.ctx.logf("Failed to forward DNS query to %s: %v", s.Upstream, err)
+		resp = new(dns.Msg)
+		return resp.SetRcode(req, dns.RcodeServerFailure)
+	}
+
+	resp := new(dns.Msg)
+	return resp.SetRcode(req, dns.RcodeRefused)
+}
@@ -0,0 +1,152 @@
+package zap
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// startDNS serves s on local UDP and TCP ports until the test ends, and returns the address.
+func startDNS(t *testing.T, s *DNSServer) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, pc, l) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("DNS server failed: %v", err)
+		}
+	})
+	return pc.LocalAddr().String()
+}
+
+func TestDNSServer(t *testing.T) {
+	Convey("Given a DNS server for the default Config", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		ctx := NewContext(c)
+		ctx.AdvertiseAddrs = []netip.Addr{netip.MustParseAddr("192.0.2.10")}
+		addr := startDNS(t, NewDNSServer(ctx, ""))
+
+		query := func(network, name string, qtype uint16) *dns.Msg {
+			m := new(dns.Msg)
+			m.SetQuestion(name, qtype)
+			resp, _, err := (&dns.Client{Net: network}).Exchange(m, addr)
+			So(err, ShouldBeNil)
+			return resp
+		}
+
+		Convey("A queries for shortcuts should get the advertised address", func() {
+			resp := query("udp", "g.", dns.TypeA)
+			So(resp.Rcode, ShouldEqual, dns.RcodeSuccess)
+			So(resp.Authoritative, ShouldBeTrue)
+			So(resp.Answer, ShouldHaveLength, 1)
+			So(resp.Answer[0].(*dns.A).A.String(), ShouldEqual, "192.0.2.10")
+			So(resp.Answer[0].Header().Ttl, ShouldEqual, dnsTTL)
+
+			resp = query("tcp", "AK.", dns.TypeA)
+			So(resp.Answer, ShouldHaveLength, 1)
+		})
+
+		Convey("AAAA queries should get no records for an IPv4 address", func() {
+			resp := query("udp", "g.", dns.TypeAAAA)
+			So(resp.Rcode, ShouldEqual, dns.RcodeSuccess)
+			So(resp.Answer, ShouldBeEmpty)
+		})
+
+		Convey("AAAA queries should be answered for an IPv6 address", func() {
+			ctx6 := NewContext(c)
+			ctx6.AdvertiseAddrs = []netip.Addr{netip.MustParseAddr("2001:db8::10")}
+			addr = startDNS(t, NewDNSServer(ctx6, ""))
+			resp := query("udp", "g.", dns.TypeAAAA)
+			So(resp.Answer, ShouldHaveLength, 1)
+			So(resp.Answer[0].(*dns.AAAA).AAAA.String(), ShouldEqual, "2001:db8::10")
+			So(query("udp", "g.", dns.TypeA).Answer, ShouldBeEmpty)
+		})
+
+		Convey("Every advertised address of the queried family should be answered", func() {
+			ctx46 := NewContext(c)
+			ctx46.AdvertiseAddrs, err = ParseAddrs("192.0.2.10, 2001:db8::10,192.0.2.11")
+			So(err, ShouldBeNil)
+			addr = startDNS(t, NewDNSServer(ctx46, ""))
+			So(query("udp", "g.", dns.TypeA).Answer, ShouldHaveLength, 2)
+			So(query("udp", "g.", dns.TypeAAAA).Answer, ShouldHaveLength, 1)
+			So(query("udp", "g.", dns.TypeANY).Answer, ShouldHaveLength, 3)
+		})
+
+		Convey("Other names should be refused without an upstream", func() {
+			So(query("udp", "example.com.", dns.TypeA).Rcode, ShouldEqual, dns.RcodeRefused)
+			So(query("udp", "g.example.com.", dns.TypeA).Rcode, ShouldEqual, dns.RcodeRefused)
+		})
+
+		Convey("Reloads should be picked up", func() {
+			n, err := parseYamlString("new:\n  expand: example.com\n")
+			So(err, ShouldBeNil)
+			ctx.SetConfig(n)
+			So(query("udp", "new.", dns.TypeA).Answer, ShouldHaveLength, 1)
+			So(query("udp", "g.", dns.TypeA).Rcode, ShouldEqual, dns.RcodeRefused)
+		})
+
+		Convey("Keys that aren't host names should not be answered, and be logged", func() {
+			var logs bytes.Buffer
+			ctx.Logger = log.New(&logs, "", 0)
+			n, err := parseYamlString("my_app:\n  expand: example.com\n")
+			So(err, ShouldBeNil)
+			ctx.SetConfig(n)
+			So(query("udp", "my_app.", dns.TypeA).Rcode, ShouldEqual, dns.RcodeRefused)
+			So(logs.String(), ShouldContainSubstring, "shortcut 'my_app', it is not a valid host name")
+		})
+	})
+
+	Convey("Given a DNS server with an upstream", t, func() {
+		upstream := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			rr, _ := dns.NewRR(req.Question[0].Name + " 300 IN A 198.51.100.1")
+			resp.Answer = append(resp.Answer, rr)
+			_ = w.WriteMsg(resp)
+		})
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		up := &dns.Server{PacketConn: pc, Handler: upstream}
+		started := make(chan struct{})
+		up.NotifyStartedFunc = func() { close(started) }
+		go func() { _ = up.ActivateAndServe() }()
+		<-started
+		defer func() { _ = up.Shutdown() }()
+
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		ctx := NewContext(c)
+		ctx.AdvertiseAddrs = []netip.Addr{netip.MustParseAddr("192.0.2.10")}
+		addr := startDNS(t, NewDNSServer(ctx, pc.LocalAddr().String()))
+
+		Convey("Other names should be forwarded", func() {
+			m := new(dns.Msg)
+			m.SetQuestion("example.com.", dns.TypeA)
+			resp, _, err := new(dns.Client).Exchange(m, addr)
+			So(err, ShouldBeNil)
+			So(resp.Answer, ShouldHaveLength, 1)
+			So(resp.Answer[0].(*dns.A).A.String(), ShouldEqual, "198.51.100.1")
+
+			m.SetQuestion("g.", dns.TypeA)
+			resp, _, err = new(dns.Client).Exchange(m, addr)
+			So(err, ShouldBeNil)
+			So(resp.Answer[0].(*dns.A).A.String(), ShouldEqual, "192.0.2.10")
+		})
+	})
+}
@@ -0,0 +1,85 @@
+package zap
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DNSExportFormats lists the formats understood by ExportDNS.
+var DNSExportFormats = []string{"dnsmasq", "unbound", "coredns-hosts", "bind-zone", "hosts"}
+
+// exportHeader heads every generated snippet, after the comment marker of its format.
+const exportHeader = "Generated by zap from the top-level shortcuts, do not edit by hand."
+
+// bindZoneFile is the file name the bind-zone format suggests in its named.conf stanzas.
+const bindZoneFile = "zap.zone"
+
+// ExportDNS writes a record for every top-level shortcut of conf and every address in
+// advertise, as a config snippet for another DNS server, so that the shortcuts resolve
+// network wide without running zap's own DNS server. ttl is in seconds, and ignored by
+// formats without one. The formats are:
+//
+//   - dnsmasq: address=/g/192.0.2.10 lines.
+//   - unbound: a server: clause with local-data records.
+//   - coredns-hosts: a hosts plugin block for a Corefile, falling through for other names.
+//   - bind-zone: a complete zone file with SOA, NS and address records relative to the
+//     origin, to be loaded as the zone of every shortcut, which are listed as named.conf
+//     zone stanzas in its comments.
+//   - hosts: the hosts file block UpdateHosts maintains.
+//
+// Keys that aren't valid host names, such as "my_app", can't be DNS records. The DNS formats
+// leave them out and return them as skipped, so that the caller can warn about them.
+func ExportDNS(w io.Writer, format string, conf *Node, advertise []netip.Addr, ttl uint32) (skipped []string, err error) {
+	hosts, skipped := dnsNames(conf)
+	var b strings.Builder
+	switch format {
+	case "dnsmasq":
+		fmt.Fprintf(&b, "# %s\n", exportHeader)
+		for _, h := range hosts {
+			for _, a := range advertise {
+				fmt.Fprintf(&b, "address=/%s/%s\n", h, a)
+			}
+		}
+	case "unbound":
+		fmt.Fprintf(&b, "# %s\nserver:\n", exportHeader)
+		for _, h := range hosts {
+			for _, a := range advertise {
+				fmt.Fprintf(&b, "    local-data: \"%s\"\n", strings.ReplaceAll(addrRR(dns.Fqdn(h), a, ttl).String(), "\t", " "))
+			}
+		}
+	case "coredns-hosts":
+		fmt.Fprintf(&b, "# %s\nhosts {\n", exportHeader)
+		for _, h := range hosts {
+			for _, a := range advertise {
+				fmt.Fprintf(&b, "    %s %s\n", a, h)
+			}
+		}
+		fmt.Fprintf(&b, "    ttl %d\n    fallthrough\n}\n", ttl)
+	case "bind-zone":
+		// Every shortcut is a zone of its own, and they all hold the same records, so names
+		// are relative to the origin and one file serves them all.
+		fmt.Fprintf(&b, "; %s\n; Save as %s and load it for every shortcut in named.conf:\n", exportHeader, bindZoneFile)
+		for _, h := range hosts {
+			fmt.Fprintf(&b, ";   zone \"%s\" { type master; file \"%s\"; };\n", h, bindZoneFile)
+		}
+		fmt.Fprintf(&b, "$TTL %d\n@\tIN\tSOA\t@ hostmaster 1 3600 600 86400 %d\n@\tIN\tNS\t@\n", ttl, ttl)
+		for _, a := range advertise {
+			rrtype := "A"
+			if a.Is6() {
+				rrtype = "AAAA"
+			}
+			fmt.Fprintf(&b, "@\tIN\t%s\t%s\n", rrtype, a)
+		}
+	case "hosts":
+		b.WriteString(hostsBlock(advertise, conf))
+		skipped = nil
+	default:
+		return nil, fmt.Errorf("unsupported DNS export format '%s', expected one of %s", format, strings.Join(DNSExportFormats, ", "))
+	}
+	_, err = io.WriteString(w, b.String())
+	return skipped, err
+}
@@ -0,0 +1,113 @@
+package zap
+
+import (
+	"bytes"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExportDNS(t *testing.T) {
+	Convey("Given a config and advertised addresses", t, func() {
+		c, err := parseYamlString("g:\n  expand: github.com\nak:\n  expand: kafka.apache.org\n")
+		So(err, ShouldBeNil)
+		advertise := []netip.Addr{netip.MustParseAddr("192.0.2.10"), netip.MustParseAddr("2001:db8::10")}
+
+		export := func(format string) string {
+			var buf bytes.Buffer
+			_, err := ExportDNS(&buf, format, c, advertise, 300)
+			So(err, ShouldBeNil)
+			return buf.String()
+		}
+
+		Convey("dnsmasq should get address lines", func() {
+			So(export("dnsmasq"), ShouldEqual, "# "+exportHeader+"\n"+
+				"address=/ak/192.0.2.10\naddress=/ak/2001:db8::10\n"+
+				"address=/g/192.0.2.10\naddress=/g/2001:db8::10\n")
+		})
+
+		Convey("unbound should get local-data records", func() {
+			So(export("unbound"), ShouldEqual, "# "+exportHeader+"\nserver:\n"+
+				"    local-data: \"ak. 300 IN A 192.0.2.10\"\n"+
+				"    local-data: \"ak. 300 IN AAAA 2001:db8::10\"\n"+
+				"    local-data: \"g. 300 IN A 192.0.2.10\"\n"+
+				"    local-data: \"g. 300 IN AAAA 2001:db8::10\"\n")
+		})
+
+		Convey("CoreDNS should get a hosts block", func() {
+			So(export("coredns-hosts"), ShouldEqual, "# "+exportHeader+"\nhosts {\n"+
+				"    192.0.2.10 ak\n    2001:db8::10 ak\n    192.0.2.10 g\n    2001:db8::10 g\n"+
+				"    ttl 300\n    fallthrough\n}\n")
+		})
+
+		Convey("BIND should get a zone file shared by every shortcut", func() {
+			zone := export("bind-zone")
+			So(zone, ShouldEqual, "; "+exportHeader+"\n"+
+				"; Save as zap.zone and load it for every shortcut in named.conf:\n"+
+				";   zone \"ak\" { type master; file \"zap.zone\"; };\n"+
+				";   zone \"g\" { type master; file \"zap.zone\"; };\n"+
+				"$TTL 300\n@\tIN\tSOA\t@ hostmaster 1 3600 600 86400 300\n@\tIN\tNS\t@\n"+
+				"@\tIN\tA\t192.0.2.10\n@\tIN\tAAAA\t2001:db8::10\n")
+
+			zp := dns.NewZoneParser(strings.NewReader(zone), "g.", "")
+			var records []string
+			for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+				records = append(records, strings.ReplaceAll(rr.String(), "\t", " "))
+			}
+			So(zp.Err(), ShouldBeNil)
+			So(records, ShouldResemble, []string{
+				"g. 300 IN SOA g. hostmaster.g. 1 3600 600 86400 300",
+				"g. 300 IN NS g.",
+				"g. 300 IN A 192.0.2.10",
+				"g. 300 IN AAAA 2001:db8::10",
+			})
+		})
+
+		Convey("hosts should get the block UpdateHosts writes", func() {
+			So(export("hosts"), ShouldEqual, hostsBlock(advertise, c))
+		})
+
+		Convey("Keys that aren't host names should be skipped by the DNS formats", func() {
+			c, err = parseYamlString("g:\n  expand: github.com\n\"*\":\n  expand: a.com\n\"g:80\":\n  expand: b.com\n" +
+				"\"a b\":\n  expand: c.com\n-x:\n  expand: d.com\n\"x..y\":\n  expand: e.com\nmy_app:\n  expand: f.com\n")
+			So(err, ShouldBeNil)
+			bad := []string{"-x", "a b", "g:80", "my_app", "x..y"}
+			names, skipped := dnsNames(c)
+			So(names, ShouldResemble, []string{"g"})
+			So(skipped, ShouldResemble, bad)
+			advertise = advertise[:1]
+			So(export("dnsmasq"), ShouldEqual, "# "+exportHeader+"\naddress=/g/192.0.2.10\n")
+			for _, format := range DNSExportFormats {
+				if format == "hosts" {
+					continue
+				}
+				var buf bytes.Buffer
+				skipped, err := ExportDNS(&buf, format, c, advertise, 300)
+				So(err, ShouldBeNil)
+				So(skipped, ShouldResemble, bad)
+				for _, k := range bad {
+					So(buf.String(), ShouldNotContainSubstring, k)
+				}
+			}
+
+			Convey("But the hosts block should keep them, like UpdateHosts", func() {
+				skipped, err := ExportDNS(io.Discard, "hosts", c, advertise, 300)
+				So(err, ShouldBeNil)
+				So(skipped, ShouldBeEmpty)
+				So(export("hosts"), ShouldContainSubstring, "192.0.2.10 my_app\n")
+			})
+		})
+
+		Convey("Unknown formats should be rejected", func() {
+			var buf bytes.Buffer
+			_, err := ExportDNS(&buf, "djbdns", c, advertise, 300)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "dnsmasq")
+			So(buf.Len(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,31 @@
+package zap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temporary file next to fname, then renames it into place.
+func writeFileAtomic(fname string, data []byte, perm os.FileMode) error {
+	tmp, err := Afero.TempFile(filepath.Dir(fname), "."+filepath.Base(fname)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for '%s': %w", fname, err)
+	}
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = Afero.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = Afero.Rename(tmp.Name(), fname)
+	}
+	if err != nil {
+		_ = Afero.Remove(tmp.Name())
+		return fmt.Errorf("failed to replace '%s': %w", fname, err)
+	}
+	return nil
+}
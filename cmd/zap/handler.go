@@ -0,0 +1,211 @@
+package zap
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultAdminPrefix is where the shortcut directory and the preview API are served.
+const DefaultAdminPrefix = "/_zap"
+
+// DefaultHostsFile is the hosts file kept in sync with the shortcuts by the zap binary.
+const DefaultHostsFile = "/etc/hosts"
+
+// Option configures the handler returned by NewHandler.
+type Option func(*handlerOptions)
+
+type handlerOptions struct {
+	ctx         *Context
+	configFile  string
+	logger      *log.Logger
+	adminPrefix string
+	hostsFile   *string
+	registerer  prometheus.Registerer
+	stats       *Stats
+	accessLog   *AccessLog
+	reloadToken string
+}
+
+// WithContext serves the Config held by ctx. Use this to control hot reload, see
+// MakeReloadCallback, or to set AdvertiseAddrs and ProxyTransport.
+//
+// The handler shares ctx rather than copying it, so the other options write to its fields:
+// WithConfigFile sets ConfigFile and the Config, and WithLogger, WithHostsFile, WithStats,
+// WithAccessLog and WithReloadToken set Logger, HostsFile, Stats, AccessLog and ReloadToken.
+// If ctx.HostsFile is set, either way, NewHandler writes the hosts file.
+func WithContext(ctx *Context) Option {
+	return func(o *handlerOptions) { o.ctx = ctx }
+}
+
+// WithConfig serves a fixed, already compiled Config.
+func WithConfig(c *Node) Option {
+	return func(o *handlerOptions) { o.ctx = NewContext(c) }
+}
+
+// WithConfigFile loads and validates fname when the handler is created. If the file is
+// invalid the error is logged, and requests fail with 500 until a valid Config is set.
+func WithConfigFile(fname string) Option {
+	return func(o *handlerOptions) { o.configFile = fname }
+}
+
+// WithLogger sets the logger for reload, hosts file and proxy messages. Defaults to the
+// standard logger.
+func WithLogger(l *log.Logger) Option {
+	return func(o *handlerOptions) { o.logger = l }
+}
+
+// WithAdminPrefix moves the shortcut directory, the preview API, usage stats and reload from "/_zap" to prefix,
+// e.g. when "/_zap" is taken on the mux zap is mounted on. A missing leading slash is added. An empty
+// prefix or "/" would hide the shortcuts, so DefaultAdminPrefix is kept instead.
+func WithAdminPrefix(prefix string) Option {
+	return func(o *handlerOptions) {
+		if p := strings.Trim(prefix, "/"); p != "" {
+			o.adminPrefix = "/" + p
+		}
+	}
+}
+
+// WithHostsFile keeps fname in sync with the top-level shortcuts, on creation and on every
+// reload. An empty name disables hosts file updates, which is the default.
+func WithHostsFile(fname string) Option {
+	return func(o *handlerOptions) { o.hostsFile = &fname }
+}
+
+// WithRegisterer registers zap's Prometheus metrics with reg. If reg is also a
+// prometheus.Gatherer, such as a *prometheus.Registry, it is served on /metrics.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(o *handlerOptions) { o.registerer = reg }
+}
+
+// WithStats records hits per shortcut in s, and serves a usage report on the stats
+// endpoint. Saving s is up to the caller, see Stats.Run.
+func WithStats(s *Stats) Option {
+	return func(o *handlerOptions) { o.stats = s }
+}
+
+// WithAccessLog logs every request to a, see AccessLog.
+func WithAccessLog(a *AccessLog) Option {
+	return func(o *handlerOptions) { o.accessLog = a }
+}
+
+// WithReloadToken enables POST requests to the reload endpoint carrying the token as
+// "Authorization: Bearer <token>". The config file is reread, so this needs WithConfigFile
+// or a Context with ConfigFile set.
+func WithReloadToken(token string) Option {
+	return func(o *handlerOptions) { o.reloadToken = token }
+}
+
+// NewHandler returns an http.Handler serving shortcuts along with the /varz, /healthz,
+// /metrics, directory and preview endpoints, so that zap can be mounted on any mux.
+// Options are applied to the Context given with WithContext, see there, or to a new one.
+func NewHandler(opts ...Option) http.Handler {
+	o := handlerOptions{adminPrefix: DefaultAdminPrefix}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx := o.ctx
+	if ctx == nil {
+		ctx = NewContext(nil)
+	}
+	if o.logger != nil {
+		ctx.Logger = o.logger
+	}
+	if o.hostsFile != nil {
+		ctx.HostsFile = *o.hostsFile
+	}
+	if o.stats != nil {
+		ctx.Stats = o.stats
+	}
+	if o.accessLog != nil {
+		ctx.AccessLog = o.accessLog
+	}
+	if o.reloadToken != "" {
+		ctx.ReloadToken = o.reloadToken
+	}
+	if o.configFile != "" {
+		ctx.ConfigFile = o.configFile
+		c, err := ParseYaml(o.configFile)
+		if err == nil {
+			err = ValidateConfig(c)
+		}
+		if err != nil {
+			ctx.logf("Failed to load config file '%s': %v", o.configFile, err)
+		} else {
+			ctx.SetConfig(c)
+		}
+	}
+
+	if o.registerer != nil {
+		m, err := newMetrics(ctx, o.registerer)
+		if err != nil {
+			ctx.logf("Failed to register metrics: %v", err)
+		}
+		ctx.metrics = m
+	}
+
+	if ctx.HostsFile != "" && ctx.Config() != nil {
+		// Try to update hosts file, but don't fail if we can't
+		if err := UpdateHosts(ctx); err != nil {
+			ctx.logf("Warning: Failed to update hosts file '%s': %v", ctx.HostsFile, err)
+			ctx.logf("Server will continue running, but DNS shortcuts may not work")
+		}
+	}
+
+	router := httprouter.New()
+	router.Handler("GET", "/", CtxWrapper{Context: ctx, H: IndexHandler})
+	router.Handler("GET", "/varz", CtxWrapper{Context: ctx, H: VarsHandler})
+	router.HandlerFunc("GET", "/healthz", HealthHandler)
+	router.Handler("GET", PACPath, CtxWrapper{Context: ctx, H: PACHandler})
+	if g, ok := o.registerer.(prometheus.Gatherer); ok {
+		router.Handler("GET", MetricsPath, metricsHandler(ctx, g))
+	}
+	router.Handler("GET", o.adminPrefix, CtxWrapper{Context: ctx, H: selfOnly(func(ctx *Context, w http.ResponseWriter, r *http.Request) (int, error) {
+		http.Redirect(w, r, o.adminPrefix+"/", http.StatusMovedPermanently)
+		return http.StatusMovedPermanently, nil
+	})})
+	router.Handler("GET", o.adminPrefix+"/", CtxWrapper{Context: ctx, H: selfOnly(DirectoryHandler)})
+	router.Handler("GET", o.adminPrefix+"/expand", CtxWrapper{Context: ctx, H: selfOnly(ExpandHandler)})
+	router.Handler("GET", o.adminPrefix+"/stats", CtxWrapper{Context: ctx, H: selfOnly(StatsHandler)})
+	router.Handler("POST", o.adminPrefix+"/reload", CtxWrapper{Context: ctx, H: selfOnly(ReloadHandler)})
+
+	// Cleaned up paths belong to the shortcut, g/_ZAP must not be redirected to /_zap.
+	router.RedirectTrailingSlash = false
+	router.RedirectFixedPath = false
+
+	// https://github.com/julienschmidt/httprouter is having issues with
+	// wildcard handling. As a result, we have to register index handler
+	// as the fallback. Fix incoming.
+	router.NotFound = CtxWrapper{Context: ctx, H: IndexHandler}
+
+	// Send other methods to the index handler too, so that 307/308 redirects and
+	// proxied shortcuts work for POST and friends.
+	router.HandleMethodNotAllowed = false
+	return router
+}
+
+// selfOnly serves admin pages with h for requests addressed to zap itself, like the
+// directory on "/", and expands shortcuts otherwise, so that g/_zap keeps working.
+func selfOnly(h func(*Context, http.ResponseWriter, *http.Request) (int, error)) func(*Context, http.ResponseWriter, *http.Request) (int, error) {
+	return func(ctx *Context, w http.ResponseWriter, r *http.Request) (int, error) {
+		if !forSelf(ctx, r) {
+			return IndexHandler(ctx, w, r)
+		}
+		return h(ctx, w, r)
+	}
+}
+
+// forSelf reports whether r is addressed to zap itself rather than to a shortcut.
+func forSelf(ctx *Context, r *http.Request) bool {
+	host := requestHost(r)
+	if conf := ctx.Config(); conf != nil {
+		if _, _, ok := conf.lookupHost(host); ok {
+			return false
+		}
+	}
+	return isSelfHost(ctx, host)
+}
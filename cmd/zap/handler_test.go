@@ -0,0 +1,161 @@
+package zap
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewHandler(t *testing.T) {
+	Convey("Given a handler serving the default Config", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		handler := NewHandler(WithConfig(c))
+
+		get := func(h http.Handler, host, path string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Host = host
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			return rr
+		}
+
+		Convey("It should redirect shortcuts and serve the status endpoints", func() {
+			rr := get(handler, "g", "/z")
+			So(rr.Code, ShouldEqual, http.StatusFound)
+			So(rr.Header().Get("Location"), ShouldEqual, "https://github.com/issmirnov/zap")
+
+			So(get(handler, "localhost", "/healthz").Code, ShouldEqual, http.StatusOK)
+			So(get(handler, "localhost", "/varz").Code, ShouldEqual, http.StatusOK)
+			So(get(handler, "localhost", DirectoryPath).Code, ShouldEqual, http.StatusOK)
+			So(get(handler, "localhost", ExpandPreviewPath+"?q=g/z").Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("It should accept methods other than GET", func() {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/z", nil)
+			req.Host = "g"
+			handler.ServeHTTP(rr, req)
+			So(rr.Code, ShouldEqual, http.StatusFound)
+		})
+
+		Convey("With an admin prefix, the directory and preview API should move", func() {
+			h := NewHandler(WithConfig(c), WithAdminPrefix("/admin/"))
+			So(get(h, "localhost", "/admin/").Code, ShouldEqual, http.StatusOK)
+			So(get(h, "localhost", "/admin/expand?q=g/z").Code, ShouldEqual, http.StatusOK)
+
+			rr := get(h, "localhost", "/admin/expand")
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+			So(rr.Body.String(), ShouldContainSubstring, "/admin/expand?q=")
+
+			So(get(h, "localhost", ExpandPreviewPath+"?q=g/z").Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Admin prefixes should be cleaned up rather than panic", func() {
+			h := NewHandler(WithConfig(c), WithAdminPrefix("admin"))
+			So(get(h, "localhost", "/admin/").Code, ShouldEqual, http.StatusOK)
+
+			for _, prefix := range []string{"", "/", "//"} {
+				h := NewHandler(WithConfig(c), WithAdminPrefix(prefix))
+				So(get(h, "localhost", DirectoryPath).Code, ShouldEqual, http.StatusOK)
+				So(get(h, "g", "/z").Code, ShouldEqual, http.StatusFound)
+			}
+		})
+
+		Convey("Admin paths should only be served to zap itself", func() {
+			rr := get(handler, "localhost", DefaultAdminPrefix)
+			So(rr.Code, ShouldEqual, http.StatusMovedPermanently)
+			So(rr.Header().Get("Location"), ShouldEqual, DirectoryPath)
+
+			for _, path := range []string{DefaultAdminPrefix, DirectoryPath, DirectoryPath + "stats", DirectoryPath + "expand", "/_ZAP"} {
+				rr := get(handler, "g", path)
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://github.com"+path)
+			}
+		})
+
+		Convey("With a shared context, reloads should be served", func() {
+			ctx := NewContext(c)
+			h := NewHandler(WithContext(ctx))
+			So(get(h, "g", "/z").Code, ShouldEqual, http.StatusFound)
+
+			n, err := parseYamlString("g:\n  expand: gitlab.com\n")
+			So(err, ShouldBeNil)
+			ctx.SetConfig(n)
+			So(get(h, "g", "/z").Header().Get("Location"), ShouldEqual, "https://gitlab.com/z")
+		})
+
+		Convey("With a shared context, options should be written to it", func() {
+			ctx := NewContext(c)
+			logger := log.New(io.Discard, "", 0)
+			stats := NewStats()
+			NewHandler(WithContext(ctx), WithLogger(logger), WithStats(stats), WithReloadToken("s3cret"))
+			So(ctx.Logger, ShouldEqual, logger)
+			So(ctx.Stats, ShouldEqual, stats)
+			So(ctx.ReloadToken, ShouldEqual, "s3cret")
+		})
+	})
+
+	Convey("Given a handler loading a config file", t, func() {
+		useMemFs(t)
+		var logs bytes.Buffer
+		logger := log.New(&logs, "", 0)
+
+		Convey("It should serve a valid config", func() {
+			So(Afero.WriteFile("c.yml", []byte(cYaml), 0644), ShouldBeNil)
+			h := NewHandler(WithConfigFile("c.yml"), WithLogger(logger))
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/z", nil)
+			req.Host = "g"
+			h.ServeHTTP(rr, req)
+			So(rr.Code, ShouldEqual, http.StatusFound)
+			So(logs.String(), ShouldBeEmpty)
+		})
+
+		Convey("It should log an invalid config and fail requests", func() {
+			So(Afero.WriteFile("bad.yml", []byte(badValuesYAML), 0644), ShouldBeNil)
+			h := NewHandler(WithConfigFile("bad.yml"), WithLogger(logger))
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/z", nil)
+			req.Host = "g"
+			h.ServeHTTP(rr, req)
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+			So(logs.String(), ShouldContainSubstring, "Failed to load config file 'bad.yml'")
+		})
+	})
+}
+
+func TestHandlerHostsFile(t *testing.T) {
+	t.Setenv("ZAP_DISABLE_HOSTS_UPDATE", "")
+
+	Convey("Given a hosts file", t, func() {
+		useMemFs(t)
+		So(Afero.WriteFile("hosts", []byte("127.0.0.1 localhost\n"), 0644), ShouldBeNil)
+		c, err := parseYamlString("g:\n  expand: github.com\n")
+		So(err, ShouldBeNil)
+
+		Convey("It should be left alone by default", func() {
+			NewHandler(WithConfig(c))
+			data, err := Afero.ReadFile("hosts")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "127.0.0.1 localhost\n")
+		})
+
+		Convey("WithHostsFile should add the shortcuts to it", func() {
+			ctx := NewContext(c)
+			ctx.AdvertiseAddrs = []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+			NewHandler(WithContext(ctx), WithHostsFile("hosts"))
+			data, err := Afero.ReadFile("hosts")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "127.0.0.1 localhost\n"+delimStart+"127.0.0.1 g\n"+delimEnd)
+		})
+	})
+}
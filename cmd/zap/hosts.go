@@ -0,0 +1,224 @@
+package zap
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// HostsBackupSuffix is appended to the hosts file name to get the backup of its previous contents.
+const HostsBackupSuffix = ".zap.bak"
+
+// ParseAddrs parses a comma separated list of IPv4 and IPv6 addresses, e.g. "127.0.0.1,::1".
+// Empty entries are an error, since an empty bind address means every interface.
+func ParseAddrs(s string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, fmt.Errorf("empty entry in '%s'", s)
+		}
+		a, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address '%s': %w", v, err)
+		}
+		addrs = append(addrs, a.Unmap())
+	}
+	return addrs, nil
+}
+
+// UpdateHosts will attempt to write the zap list of shortcuts
+// to c.HostsFile, usually /etc/hosts. It will gracefully fail if there are not enough
+// permissions to do so. Can be disabled via ZAP_DISABLE_HOSTS_UPDATE env var, or by leaving
+// c.HostsFile empty.
+//
+// Only the block between the zap delimiters is touched, and the file is left alone if the
+// block is already up to date. Before the first change, the original contents are saved
+// next to it, see HostsBackupSuffix.
+func UpdateHosts(c *Context) error {
+	hostPath := c.HostsFile
+	if hostPath == "" {
+		return nil
+	}
+
+	// Check if hosts file updates are disabled (useful for containerized environments)
+	if os.Getenv("ZAP_DISABLE_HOSTS_UPDATE") != "" {
+		c.logf("Hosts file updates disabled via ZAP_DISABLE_HOSTS_UPDATE environment variable")
+		return nil
+	}
+
+	block := hostsBlock(c.advertised(), c.Config())
+	return rewriteHosts(hostPath, func(data string) (string, error) {
+		return replaceBlock(data, block)
+	})
+}
+
+// RemoveHosts removes the zap shortcuts from the hosts file fname, keeping a backup like
+// UpdateHosts. Files without shortcuts are left alone.
+func RemoveHosts(fname string) error {
+	return rewriteHosts(fname, removeBlock)
+}
+
+// rewriteHosts applies edit to the contents of the hosts file fname. If they change, the
+// old contents are backed up unless there is a backup already, and the file is replaced
+// atomically, keeping its permissions. Symlinks are followed, so that the file they point
+// to is replaced rather than the link.
+func rewriteHosts(fname string, edit func(string) (string, error)) error {
+	target, err := resolveLinks(fname)
+	if err != nil {
+		return fmt.Errorf("failed to read hosts file '%s': %w", fname, err)
+	}
+	info, err := Afero.Stat(target)
+	if err != nil {
+		return fmt.Errorf("failed to read hosts file '%s': %w", fname, err)
+	}
+	data, err := Afero.ReadFile(target)
+	if err != nil {
+		return fmt.Errorf("failed to read hosts file '%s': %w", fname, err)
+	}
+	updated, err := edit(string(data))
+	if err != nil {
+		return fmt.Errorf("refusing to edit hosts file '%s': %w", fname, err)
+	}
+	if updated == string(data) {
+		return nil
+	}
+
+	// Keep the first backup, it is the file as it was before zap touched it.
+	perm := info.Mode().Perm()
+	backup := fname + HostsBackupSuffix
+	if _, err := Afero.Stat(backup); errors.Is(err, os.ErrNotExist) {
+		if err := Afero.WriteFile(backup, data, perm); err != nil {
+			return fmt.Errorf("failed to back up hosts file '%s': %w", fname, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to back up hosts file '%s': %w", fname, err)
+	}
+	err = writeFileAtomic(target, []byte(updated), perm)
+	// Renaming fails if the file is a mount point, as /etc/hosts is in most containers,
+	// or if the file is writable but its directory isn't. Only then fall back to
+	// rewriting it in place, other errors are real.
+	if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) || errors.Is(err, os.ErrPermission) {
+		err = Afero.WriteFile(target, []byte(updated), perm)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write to hosts file '%s': %w", fname, err)
+	}
+	return nil
+}
+
+// resolveLinks returns the file that fname refers to after following symlinks. Only the
+// OS filesystem has symlinks, other filesystems return fname as is.
+func resolveLinks(fname string) (string, error) {
+	if _, ok := Afero.Fs.(*afero.OsFs); !ok {
+		return fname, nil
+	}
+	return filepath.EvalSymlinks(fname)
+}
+
+// hostsBlock returns the delimited block of hosts entries for the top-level shortcuts of conf,
+// one per shortcut and address. Shortcuts are sorted so that reloads without changes leave
+// the file alone.
+func hostsBlock(advertise []netip.Addr, conf *Node) string {
+	var b strings.Builder
+	b.WriteString(delimStart)
+	for _, k := range shortcutHosts(conf) {
+		for _, a := range advertise {
+			fmt.Fprintf(&b, "%s %s\n", a, k)
+		}
+	}
+	b.WriteString(delimEnd)
+	return b.String()
+}
+
+// hostName matches names made of letters, digits and hyphens, in dot separated labels of
+// at most 63 characters that don't start or end with a hyphen.
+var hostName = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// isHostName reports whether k is a valid DNS host name, see hostName.
+func isHostName(k string) bool {
+	return len(k) <= 253 && hostName.MatchString(k)
+}
+
+// shortcutHosts returns the top-level shortcuts of conf, the names zap answers for, sorted.
+func shortcutHosts(conf *Node) []string {
+	var keys []string
+	if conf != nil {
+		for k := range conf.Children {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dnsNames splits the top-level shortcuts of conf into the valid host names, which DNS
+// records can be written for, and the skipped keys, such as "g:80" or "my_app".
+func dnsNames(conf *Node) (names, skipped []string) {
+	for _, k := range shortcutHosts(conf) {
+		if isHostName(k) {
+			names = append(names, k)
+		} else {
+			skipped = append(skipped, k)
+		}
+	}
+	return names, skipped
+}
+
+// findBlock returns the offsets of the zap block in data, from the start of the opening
+// delimiter line to the end of the closing one. Delimiters must be whole lines. Returns
+// -1, -1 if there is no block, and an error if it is not closed.
+func findBlock(data string) (start, end int, err error) {
+	start = -1
+	for off := 0; off < len(data); {
+		next := len(data)
+		if i := strings.IndexByte(data[off:], '\n'); i >= 0 {
+			next = off + i + 1
+		}
+		line := strings.TrimSpace(data[off:next])
+		switch {
+		case start < 0 && line == strings.TrimSpace(delimStart):
+			start = off
+		case start >= 0 && line == strings.TrimSpace(delimEnd):
+			return start, next, nil
+		}
+		off = next
+	}
+	if start >= 0 {
+		return -1, -1, fmt.Errorf("found '%s' without a matching '%s'",
+			strings.TrimSpace(delimStart), strings.TrimSpace(delimEnd))
+	}
+	return -1, -1, nil
+}
+
+// replaceBlock swaps the zap block in data for block, or appends block if there is none.
+func replaceBlock(data, block string) (string, error) {
+	start, end, err := findBlock(data)
+	if err != nil {
+		return "", err
+	}
+	if start < 0 {
+		if data != "" && !strings.HasSuffix(data, "\n") {
+			data += "\n"
+		}
+		return data + block, nil
+	}
+	return data[:start] + block + data[end:], nil
+}
+
+// removeBlock drops the zap block from data, if any.
+func removeBlock(data string) (string, error) {
+	start, end, err := findBlock(data)
+	if err != nil || start < 0 {
+		return data, err
+	}
+	return data[:start] + data[end:], nil
+}
@@ -0,0 +1,213 @@
+package zap
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+// noRenameFs fails renames with err, like a bind mounted /etc/hosts does with EBUSY.
+type noRenameFs struct {
+	afero.Fs
+	err error
+}
+
+func (fs noRenameFs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.err}
+}
+
+func TestUpdateHosts(t *testing.T) {
+	t.Setenv("ZAP_DISABLE_HOSTS_UPDATE", "")
+
+	Convey("Given a hosts file", t, func() {
+		useMemFs(t)
+		const orig = "127.0.0.1 localhost\n### my own block ##\n10.0.0.1 nas\n### end of my block ##\n"
+		So(Afero.WriteFile("hosts", []byte(orig), 0640), ShouldBeNil)
+
+		c, err := parseYamlString("g:\n  expand: github.com\nak:\n  expand: kafka.apache.org\n")
+		So(err, ShouldBeNil)
+		ctx := NewContext(c)
+		ctx.AdvertiseAddrs = []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+		ctx.HostsFile = "hosts"
+		block := delimStart + "127.0.0.1 ak\n127.0.0.1 g\n" + delimEnd
+
+		read := func(fname string) string {
+			data, err := Afero.ReadFile(fname)
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+
+		Convey("The sorted shortcuts should be appended", func() {
+			So(UpdateHosts(ctx), ShouldBeNil)
+			So(read("hosts"), ShouldEqual, orig+block)
+
+			Convey("Keeping the permissions and a backup", func() {
+				info, err := Afero.Stat("hosts")
+				So(err, ShouldBeNil)
+				So(info.Mode().Perm(), ShouldEqual, os.FileMode(0640))
+				So(read("hosts"+HostsBackupSuffix), ShouldEqual, orig)
+			})
+
+			Convey("And be replaced on reload, leaving the rest alone", func() {
+				So(Afero.WriteFile("hosts", []byte(read("hosts")+"10.0.0.2 printer\n"), 0640), ShouldBeNil)
+				n, err := parseYamlString("f:\n  expand: facebook.com\n")
+				So(err, ShouldBeNil)
+				ctx.SetConfig(n)
+				So(UpdateHosts(ctx), ShouldBeNil)
+				So(read("hosts"), ShouldEqual, orig+delimStart+"127.0.0.1 f\n"+delimEnd+"10.0.0.2 printer\n")
+			})
+
+			Convey("And not be rewritten if nothing changed", func() {
+				So(Afero.Remove("hosts"+HostsBackupSuffix), ShouldBeNil)
+				So(UpdateHosts(ctx), ShouldBeNil)
+				_, err := Afero.Stat("hosts" + HostsBackupSuffix)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+
+			Convey("And be removed by RemoveHosts", func() {
+				So(RemoveHosts("hosts"), ShouldBeNil)
+				So(read("hosts"), ShouldEqual, orig)
+				So(read("hosts"+HostsBackupSuffix), ShouldEqual, orig)
+			})
+
+			Convey("And keep the first backup on later changes", func() {
+				n, err := parseYamlString("f:\n  expand: facebook.com\n")
+				So(err, ShouldBeNil)
+				ctx.SetConfig(n)
+				So(UpdateHosts(ctx), ShouldBeNil)
+				So(read("hosts"+HostsBackupSuffix), ShouldEqual, orig)
+			})
+		})
+
+		Convey("Every advertised address should get an entry", func() {
+			ctx.AdvertiseAddrs, err = ParseAddrs("127.0.0.1,::1")
+			So(err, ShouldBeNil)
+			So(UpdateHosts(ctx), ShouldBeNil)
+			So(read("hosts"), ShouldEqual, orig+delimStart+"127.0.0.1 ak\n::1 ak\n127.0.0.1 g\n::1 g\n"+delimEnd)
+		})
+
+		Convey("Advertise should be used when AdvertiseAddrs is empty", func() {
+			ctx.AdvertiseAddrs = nil
+			ctx.Advertise = "10.0.0.5, ::1"
+			So(UpdateHosts(ctx), ShouldBeNil)
+			So(read("hosts"), ShouldEqual, orig+delimStart+"10.0.0.5 ak\n::1 ak\n10.0.0.5 g\n::1 g\n"+delimEnd)
+		})
+
+		Convey("Keys that aren't DNS host names should still be listed", func() {
+			n, err := parseYamlString("my_app:\n  expand: example.com\n")
+			So(err, ShouldBeNil)
+			ctx.SetConfig(n)
+			So(UpdateHosts(ctx), ShouldBeNil)
+			So(read("hosts"), ShouldEqual, orig+delimStart+"127.0.0.1 my_app\n"+delimEnd)
+		})
+
+		Convey("A file without a trailing newline should get one", func() {
+			So(Afero.WriteFile("hosts", []byte("127.0.0.1 localhost"), 0644), ShouldBeNil)
+			So(UpdateHosts(ctx), ShouldBeNil)
+			So(read("hosts"), ShouldEqual, "127.0.0.1 localhost\n"+block)
+		})
+
+		Convey("A start delimiter without an end should be an error", func() {
+			broken := orig + delimStart + "127.0.0.1 g\n"
+			So(Afero.WriteFile("hosts", []byte(broken), 0644), ShouldBeNil)
+			So(UpdateHosts(ctx), ShouldNotBeNil)
+			So(RemoveHosts("hosts"), ShouldNotBeNil)
+			So(read("hosts"), ShouldEqual, broken)
+		})
+
+		Convey("Files that can't be renamed over should be written in place", func() {
+			fs := Afero.Fs
+			for _, errno := range []syscall.Errno{syscall.EBUSY, syscall.EXDEV, syscall.EACCES, syscall.EPERM} {
+				So(Afero.WriteFile("hosts", []byte(orig), 0640), ShouldBeNil)
+				Afero = &afero.Afero{Fs: noRenameFs{fs, errno}}
+				So(UpdateHosts(ctx), ShouldBeNil)
+				So(read("hosts"), ShouldEqual, orig+block)
+			}
+		})
+
+		Convey("Other rename errors should not be papered over", func() {
+			Afero = &afero.Afero{Fs: noRenameFs{Afero.Fs, syscall.EIO}}
+			err := UpdateHosts(ctx)
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, syscall.EIO), ShouldBeTrue)
+			So(read("hosts"), ShouldEqual, orig)
+		})
+
+		Convey("A missing file should be an error", func() {
+			ctx.HostsFile = "missing"
+			So(UpdateHosts(ctx), ShouldNotBeNil)
+			So(RemoveHosts("missing"), ShouldNotBeNil)
+		})
+
+		Convey("ZAP_DISABLE_HOSTS_UPDATE should disable updates", func() {
+			t.Setenv("ZAP_DISABLE_HOSTS_UPDATE", "1")
+			So(UpdateHosts(ctx), ShouldBeNil)
+			So(read("hosts"), ShouldEqual, orig)
+		})
+	})
+}
+
+func TestUpdateHostsSymlink(t *testing.T) {
+	t.Setenv("ZAP_DISABLE_HOSTS_UPDATE", "")
+
+	Convey("Given a hosts file behind a symlink", t, func() {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "hosts.real")
+		link := filepath.Join(dir, "hosts")
+		So(os.WriteFile(target, []byte("127.0.0.1 localhost\n"), 0644), ShouldBeNil)
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+
+		c, err := parseYamlString("g:\n  expand: github.com\n")
+		So(err, ShouldBeNil)
+		ctx := NewContext(c)
+		ctx.AdvertiseAddrs = []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+		ctx.HostsFile = link
+
+		Convey("The file it points to should be rewritten, keeping the link", func() {
+			So(UpdateHosts(ctx), ShouldBeNil)
+			info, err := os.Lstat(link)
+			So(err, ShouldBeNil)
+			So(info.Mode()&os.ModeSymlink, ShouldNotEqual, 0)
+
+			data, err := os.ReadFile(target)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "127.0.0.1 localhost\n"+delimStart+"127.0.0.1 g\n"+delimEnd)
+		})
+	})
+}
+
+func TestParseAddrs(t *testing.T) {
+	Convey("Given comma separated addresses", t, func() {
+		Convey("IPv4 and IPv6 addresses should be parsed", func() {
+			addrs, err := ParseAddrs(" 127.0.0.1, ::1, ::ffff:10.0.0.1")
+			So(err, ShouldBeNil)
+			So(addrs, ShouldResemble, []netip.Addr{
+				netip.MustParseAddr("127.0.0.1"),
+				netip.MustParseAddr("::1"),
+				netip.MustParseAddr("10.0.0.1"),
+			})
+		})
+
+		Convey("Empty entries should be rejected", func() {
+			for _, s := range []string{"", " ", "127.0.0.1,", "127.0.0.1,,::1"} {
+				_, err := ParseAddrs(s)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "empty entry")
+			}
+		})
+
+		Convey("Hostnames should be rejected", func() {
+			_, err := ParseAddrs("127.0.0.1,localhost")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "'localhost'")
+		})
+	})
+}
@@ -0,0 +1,179 @@
+package zap
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsPath serves Prometheus metrics, if a registry is configured with WithRegisterer.
+const MetricsPath = "/metrics"
+
+// metrics holds the Prometheus collectors of a Context. A nil *metrics records nothing,
+// so handlers don't need to check whether metrics are enabled.
+type metrics struct {
+	requests   *prometheus.CounterVec
+	expansion  prometheus.Histogram
+	reloads    *prometheus.CounterVec
+	lastReload prometheus.Gauge
+}
+
+// newMetrics registers the zap collectors with reg. Collectors that are already
+// registered, e.g. by a second handler sharing the registry, are reused.
+func newMetrics(ctx *Context, reg prometheus.Registerer) (*metrics, error) {
+	m := &metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zap_requests_total",
+			Help: "Requests handled, by status code and top-level shortcut. Unknown hosts have an empty shortcut.",
+		}, []string{"code", "shortcut"}),
+		expansion: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "zap_expansion_duration_seconds",
+			Help:    "Time taken to expand a shortcut into its target URL.",
+			Buckets: prometheus.ExponentialBuckets(1e-6, 4, 10), // 1µs to ~260ms
+		}),
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zap_config_reloads_total",
+			Help: "Config reloads, by result. Failed reloads keep serving the previous config.",
+		}, []string{"result"}),
+		lastReload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "zap_config_last_reload_success_timestamp_seconds",
+			Help: "Unix time the current config was loaded.",
+		}),
+	}
+
+	var err error
+	m.requests = register(reg, m.requests, &err)
+	m.expansion = register(reg, m.expansion, &err)
+	m.reloads = register(reg, m.reloads, &err)
+	m.lastReload = register(reg, m.lastReload, &err)
+	register(reg, &configCollector{contexts: make(map[*Context]struct{})}, &err).add(ctx)
+
+	// Both results start at zero, so alerts on failures work before the first one.
+	m.reloads.WithLabelValues("success")
+	m.reloads.WithLabelValues("failure")
+	if ctx.Config() != nil {
+		m.lastReload.SetToCurrentTime()
+	}
+	return m, err
+}
+
+// register registers c with reg, returning the already registered collector if there
+// is one. Other errors are stored in err, and c is returned unregistered.
+func register[T prometheus.Collector](reg prometheus.Registerer, c T, err *error) T {
+	if e := reg.Register(c); e != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(e, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		if *err == nil {
+			*err = e
+		}
+	}
+	return c
+}
+
+var (
+	shortcutsDesc = prometheus.NewDesc("zap_shortcuts",
+		"Number of shortcuts in the current config, at any depth.", nil, nil)
+	shortcutHostsDesc = prometheus.NewDesc("zap_shortcut_hosts",
+		"Number of top-level shortcuts in the current config.", nil, nil)
+)
+
+// configCollector reports the size of the configs served by every Context registered with
+// it. Handlers sharing a registry share the collector, and their sizes are summed, like the
+// requests they count.
+type configCollector struct {
+	mu       sync.Mutex
+	contexts map[*Context]struct{}
+}
+
+// add includes the config of ctx in the reported sizes.
+func (c *configCollector) add(ctx *Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.contexts[ctx] = struct{}{}
+}
+
+// Describe implements prometheus.Collector.
+func (c *configCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- shortcutsDesc
+	ch <- shortcutHostsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *configCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var shortcuts, hosts int
+	for ctx := range c.contexts {
+		if conf := ctx.Config(); conf != nil {
+			shortcuts += countNodes(conf) - 1 // don't count the root
+			hosts += len(conf.Children)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(shortcutsDesc, prometheus.GaugeValue, float64(shortcuts))
+	ch <- prometheus.MustNewConstMetric(shortcutHostsDesc, prometheus.GaugeValue, float64(hosts))
+}
+
+// countNodes returns the number of nodes in the tree below and including n.
+func countNodes(n *Node) int {
+	if n == nil {
+		return 0
+	}
+	count := 1
+	for _, child := range n.Children {
+		count += countNodes(child)
+	}
+	return count + countNodes(n.Wildcard)
+}
+
+// countRequest records a handled request. shortcut must be a configured top-level key
+// or empty, to keep the number of series bounded.
+func (m *metrics) countRequest(status int, shortcut string) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(strconv.Itoa(status), shortcut).Inc()
+}
+
+// observeExpansion records the time taken by a single expansion.
+func (m *metrics) observeExpansion(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.expansion.Observe(d.Seconds())
+}
+
+// reloaded records the outcome of a config reload.
+func (m *metrics) reloaded(err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.reloads.WithLabelValues("failure").Inc()
+		return
+	}
+	m.reloads.WithLabelValues("success").Inc()
+	m.lastReload.SetToCurrentTime()
+}
+
+// metricsHandler serves the metrics gathered by g to requests addressed to zap
+// itself, and expands shortcuts otherwise, so that g/metrics keeps working.
+func metricsHandler(ctx *Context, g prometheus.Gatherer) http.Handler {
+	h := promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+	index := CtxWrapper{Context: ctx, H: IndexHandler}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !forSelf(ctx, r) {
+			index.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,114 @@
+package zap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Setenv("ZAP_DISABLE_HOSTS_UPDATE", "1")
+
+	Convey("Given a handler with a metrics registry", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		ctx := NewContext(c)
+		reg := prometheus.NewRegistry()
+		handler := NewHandler(WithContext(ctx), WithRegisterer(reg))
+
+		get := func(host, path string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Host = host
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			return rr
+		}
+		scrape := func() string {
+			rr := get("localhost", MetricsPath)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			return rr.Body.String()
+		}
+
+		Convey("It should count requests by status and top-level shortcut", func() {
+			get("g", "/z")
+			get("g", "/s/foo")
+			get("nope", "/x")
+			get("nope.example.com", "/y")
+
+			body := scrape()
+			So(body, ShouldContainSubstring, `zap_requests_total{code="302",shortcut="g"} 2`)
+			So(body, ShouldContainSubstring, `zap_requests_total{code="404",shortcut=""} 2`)
+			So(body, ShouldContainSubstring, "zap_expansion_duration_seconds_count 4")
+		})
+
+		Convey("It should report the size of the config", func() {
+			body := scrape()
+			So(body, ShouldContainSubstring, "zap_shortcut_hosts 10")
+			So(body, ShouldContainSubstring, "zap_shortcuts 36")
+		})
+
+		Convey("It should count reloads and keep the last success time", func() {
+			useMemFs(t)
+			So(Afero.WriteFile("c.yml", []byte(cYaml), 0644), ShouldBeNil)
+			So(Afero.WriteFile("bad.yml", []byte(badValuesYAML), 0644), ShouldBeNil)
+
+			body := scrape()
+			So(body, ShouldContainSubstring, `zap_config_reloads_total{result="failure"} 0`)
+			So(body, ShouldContainSubstring, "zap_config_last_reload_success_timestamp_seconds ")
+
+			MakeReloadCallback(ctx, "c.yml")()
+			MakeReloadCallback(ctx, "bad.yml")()
+			MakeReloadCallback(ctx, "missing.yml")()
+
+			body = scrape()
+			So(body, ShouldContainSubstring, `zap_config_reloads_total{result="success"} 1`)
+			So(body, ShouldContainSubstring, `zap_config_reloads_total{result="failure"} 2`)
+		})
+
+		Convey("Shortcut hosts should still expand /metrics", func() {
+			for _, path := range []string{MetricsPath, "/Metrics"} {
+				rr := get("g", path)
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://github.com"+path)
+			}
+			So(get("zap.example.com", MetricsPath).Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("A second handler on the same registry should share the collectors", func() {
+			other := NewHandler(WithConfig(c), WithRegisterer(reg))
+			req := httptest.NewRequest("GET", "/z", nil)
+			req.Host = "g"
+			other.ServeHTTP(httptest.NewRecorder(), req)
+			get("g", "/z")
+
+			So(scrape(), ShouldContainSubstring, `zap_requests_total{code="302",shortcut="g"} 2`)
+		})
+
+		Convey("The config size should cover every handler on the same registry", func() {
+			n, err := parseYamlString("new:\n  expand: example.com\n  a:\n    expand: a\n")
+			So(err, ShouldBeNil)
+			NewHandler(WithConfig(n), WithRegisterer(reg))
+
+			body := scrape()
+			So(body, ShouldContainSubstring, "zap_shortcut_hosts 11")
+			So(body, ShouldContainSubstring, "zap_shortcuts 38")
+		})
+	})
+
+	Convey("Given a handler without a registry", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		handler := NewHandler(WithConfig(c))
+
+		Convey("It should not serve metrics", func() {
+			req := httptest.NewRequest("GET", MetricsPath, nil)
+			req.Host = "localhost"
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,94 @@
+package zap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// PACPath serves a proxy auto-config file for the current shortcuts, see PACHandler.
+const PACPath = "/proxy.pac"
+
+// pacScript routes plain http:// requests for shortcut hosts through zap. Zap only expands
+// plain requests, so https:// is left alone, as is everything else.
+var pacScript = template.Must(template.New("pac").Parse(`// Generated by zap from the top-level shortcuts.
+var shortcuts = {{.Shortcuts}};
+
+function FindProxyForURL(url, host) {
+  if (url.substring(0, 5) == "http:" && isPlainHostName(host) &&
+      shortcuts.indexOf(host.toLowerCase()) >= 0) {
+    return {{.Proxy}};
+  }
+  return "DIRECT";
+}
+`))
+
+// PACHandler serves a proxy auto-config script that sends requests for single-label shortcut
+// hosts to zap, and everything else DIRECT, so that browsers can use the shortcuts without
+// hosts file or DNS changes. It is generated from the current Config on every request, so it
+// follows reloads. Zap is reached on the advertised addresses and on the port the script was
+// fetched from.
+func PACHandler(ctx *Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	conf := ctx.Config()
+	if conf == nil {
+		return http.StatusInternalServerError, fmt.Errorf("configuration not loaded or invalid")
+	}
+	// Browsers using the script send shortcut requests here, and g/proxy.pac is a shortcut.
+	if _, _, ok := conf.lookupHost(requestHost(r)); ok {
+		return IndexHandler(ctx, w, r)
+	}
+
+	shortcuts := []string{}
+	for _, h := range shortcutHosts(conf) {
+		if !strings.Contains(h, ".") {
+			shortcuts = append(shortcuts, strings.ToLower(h))
+		}
+	}
+	// Both end up in JavaScript, and the proxy comes from the request, so encode them as JSON.
+	hosts, err := json.Marshal(shortcuts)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to encode shortcuts: %w", err)
+	}
+	proxy, err := json.Marshal(pacProxies(ctx, r))
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to encode proxy: %w", err)
+	}
+
+	var script strings.Builder
+	if err := pacScript.Execute(&script, map[string]string{
+		"Shortcuts": string(hosts),
+		"Proxy":     string(proxy),
+	}); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to render proxy auto-config: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, script.String()); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to write response: %w", err)
+	}
+	return http.StatusOK, nil
+}
+
+// pacProxies returns the PAC proxy list for zap, e.g. "PROXY 127.0.0.1:8927; PROXY [::1]:8927".
+// Without advertised addresses, the host the script was fetched from is used.
+func pacProxies(ctx *Context, r *http.Request) string {
+	host, port, err := net.SplitHostPort(requestHost(r))
+	if err != nil {
+		host, port = strings.Trim(requestHost(r), "[]"), "80"
+	}
+
+	var proxies []string
+	for _, a := range ctx.advertised() {
+		proxies = append(proxies, "PROXY "+net.JoinHostPort(a.String(), port))
+	}
+	if len(proxies) == 0 {
+		proxies = append(proxies, "PROXY "+net.JoinHostPort(host, port))
+	}
+	return strings.Join(proxies, "; ")
+}
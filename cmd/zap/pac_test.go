@@ -0,0 +1,101 @@
+package zap
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPACHandler(t *testing.T) {
+	Convey("Given a handler with a config", t, func() {
+		c, err := parseYamlString("g:\n  expand: github.com\nAK:\n  expand: kafka.apache.org\ngo.dev:\n  expand: go.dev\n")
+		So(err, ShouldBeNil)
+		ctx := NewContext(c)
+		handler := NewHandler(WithContext(ctx))
+
+		get := func(host string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", PACPath, nil)
+			req.Host = host
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			return rr
+		}
+
+		Convey("The script should route single-label shortcuts to zap", func() {
+			ctx.AdvertiseAddrs = []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")}
+			rr := get("localhost:8927")
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Header().Get("Content-Type"), ShouldEqual, "application/x-ns-proxy-autoconfig")
+			So(rr.Body.String(), ShouldContainSubstring, `var shortcuts = ["ak","g"];`)
+			So(rr.Body.String(), ShouldContainSubstring, `return "PROXY 127.0.0.1:8927; PROXY [::1]:8927";`)
+			So(rr.Body.String(), ShouldContainSubstring, `return "DIRECT";`)
+		})
+
+		Convey("Without advertised addresses the requested host should be used", func() {
+			So(get("zap").Body.String(), ShouldContainSubstring, `return "PROXY zap:80";`)
+			So(get("[::1]:8927").Body.String(), ShouldContainSubstring, `return "PROXY [::1]:8927";`)
+		})
+
+		Convey("Reloads should be picked up", func() {
+			n, err := parseYamlString("new:\n  expand: example.com\n")
+			So(err, ShouldBeNil)
+			ctx.SetConfig(n)
+			So(get("localhost").Body.String(), ShouldContainSubstring, `var shortcuts = ["new"];`)
+		})
+
+		Convey("Requests for a shortcut host should be expanded instead", func() {
+			rr := get("g")
+			So(rr.Code, ShouldEqual, http.StatusFound)
+			So(rr.Header().Get("Location"), ShouldEqual, "https://github.com/proxy.pac")
+		})
+
+		Convey("Forwarded hosts should not break out of the script", func() {
+			req := httptest.NewRequest("GET", PACPath, nil)
+			req.Header.Set("X-Forwarded-Host", `evil"; alert(1); "`)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			So(rr.Body.String(), ShouldContainSubstring, `return "PROXY evil\"; alert(1); \":80";`)
+		})
+	})
+
+	Convey("Given a proxied shortcut with a mixed case key", t, func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "hello from "+r.URL.Path)
+		}))
+		defer backend.Close()
+
+		c, err := parseYamlString(strings.Replace(proxyConfig(backend.URL), "\np:", "\nAK:", 1))
+		So(err, ShouldBeNil)
+		ctx := NewContext(c)
+		ctx.AdvertiseAddrs = []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+		zap := httptest.NewServer(NewHandler(WithContext(ctx)))
+		defer zap.Close()
+
+		Convey("The host the script lists should be proxied by zap", func() {
+			resp, err := http.Get(zap.URL + PACPath)
+			So(err, ShouldBeNil)
+			script, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(err, ShouldBeNil)
+			So(string(script), ShouldContainSubstring, `var shortcuts = ["ak"];`)
+
+			// A browser using the script sends the request as it would to any HTTP proxy.
+			proxy, err := url.Parse(zap.URL)
+			So(err, ShouldBeNil)
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+			resp, err = client.Get("http://ak/some/path")
+			So(err, ShouldBeNil)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(string(body), ShouldEqual, "hello from /some/path")
+		})
+	})
+}
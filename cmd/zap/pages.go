@@ -0,0 +1,127 @@
+package zap
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// notFoundPage is shown to browsers when a shortcut does not exist.
+var notFoundPage = template.Must(template.New("404").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>zap: {{.Shortcut}} not found</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 3em auto; color: #222; }
+code, a { font-family: monospace; }
+li { margin: 0.2em 0; }
+</style>
+</head>
+<body>
+<h1>Shortcut <code>{{.Shortcut}}</code> not found</h1>
+{{if .Suggestions}}
+<p>Did you mean:</p>
+<ul>
+{{range .Suggestions}}<li><a href="//{{.}}">{{.}}</a></li>
+{{end}}</ul>
+{{end}}
+{{if .Children}}
+<p>Valid keys {{if .Matched}}under <code>{{.Matched}}</code>{{else}}at the top level{{end}}:</p>
+<ul>
+{{range .Children}}<li><code>{{.}}</code></li>
+{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
+
+// directoryPage lists every shortcut, with a filter box for finding them quickly.
+var directoryPage = template.Must(template.New("directory").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>zap: shortcuts</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+input { font-size: 1.1em; padding: 0.3em; width: 30em; margin-bottom: 1em; }
+table { border-collapse: collapse; }
+td, th { text-align: left; padding: 0.2em 1em 0.2em 0; vertical-align: top; }
+td.shortcut, td.target { font-family: monospace; }
+span.note { font-size: 0.8em; background: #eee; border-radius: 0.3em; padding: 0 0.3em; margin-right: 0.3em; }
+</style>
+</head>
+<body>
+<h1>Shortcuts</h1>
+<input id="filter" type="search" placeholder="Filter shortcuts and targets" autofocus>
+<table>
+<thead><tr><th>Shortcut</th><th>Target</th><th>Notes</th></tr></thead>
+<tbody>
+{{range .}}<tr>
+<td class="shortcut" style="padding-left: {{.Depth}}em">{{.Shortcut}}</td>
+<td class="target">{{.Target}}</td>
+<td>{{range .Notes}}<span class="note">{{.}}</span>{{end}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+<script>
+document.getElementById("filter").addEventListener("input", function (e) {
+  var q = e.target.value.toLowerCase();
+  document.querySelectorAll("tbody tr").forEach(function (row) {
+    row.style.display = row.textContent.toLowerCase().indexOf(q) >= 0 ? "" : "none";
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// renderNotFound writes a 404 with suggestions, as HTML, JSON or plain text depending
+// on what the client accepts.
+func renderNotFound(w http.ResponseWriter, r *http.Request, nf *notFoundError) {
+	switch negotiate(r.Header.Get("Accept"), "text/html", "application/json") {
+	case "text/html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		_ = notFoundPage.Execute(w, nf)
+	case "application/json":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(nf)
+	default:
+		var b strings.Builder
+		fmt.Fprintf(&b, "Shortcut not found: %s\n", nf.Error())
+		if len(nf.Suggestions) > 0 {
+			fmt.Fprintf(&b, "Did you mean: %s\n", strings.Join(nf.Suggestions, ", "))
+		}
+		http.Error(w, strings.TrimSuffix(b.String(), "\n"), http.StatusNotFound)
+	}
+}
+
+// negotiate picks the offer the client prefers according to its Accept header. Ties go
+// to the offer listed first in the header. Returns "" if no offer is explicitly accepted,
+// so that wildcard-only clients such as curl fall back to plain text.
+func negotiate(accept string, offers ...string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		for _, offer := range offers {
+			if mediaType == offer && q > bestQ {
+				best, bestQ = offer, q
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,17 @@
+package zap
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNegotiate(t *testing.T) {
+	Convey("Given various Accept headers", t, func() {
+		So(negotiate("", "text/html", "application/json"), ShouldEqual, "")
+		So(negotiate("*/*", "text/html", "application/json"), ShouldEqual, "")
+		So(negotiate("application/json", "text/html", "application/json"), ShouldEqual, "application/json")
+		So(negotiate("text/html,application/xhtml+xml,*/*;q=0.8", "text/html", "application/json"), ShouldEqual, "text/html")
+		So(negotiate("text/html;q=0.5, application/json", "text/html", "application/json"), ShouldEqual, "application/json")
+	})
+}
@@ -0,0 +1,75 @@
+package zap
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// DefaultProxyTransport is shared by all shortcuts in proxy mode, so that connections
+// to backends are reused across requests.
+var DefaultProxyTransport http.RoundTripper = NewProxyTransport(30 * time.Second)
+
+// NewProxyTransport returns a transport for proxy mode that gives up on backends which
+// take longer than timeout to connect or to send response headers. Response bodies are
+// not limited, so long-polling endpoints and websockets keep working.
+func NewProxyTransport(timeout time.Duration) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// serveProxy forwards the request to target and streams the response back.
+// Hop-by-hop headers are stripped, X-Forwarded-* headers are set and protocol
+// upgrades such as websockets are passed through.
+func serveProxy(ctx *Context, w http.ResponseWriter, r *http.Request, target string) (int, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("invalid proxy target '%s': %w", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return http.StatusInternalServerError, fmt.Errorf("proxy target '%s' must use http or https", target)
+	}
+
+	transport := ctx.ProxyTransport
+	if transport == nil {
+		transport = DefaultProxyTransport
+	}
+
+	// Report the backend's status, so that metrics and access logs see what the client got.
+	status := http.StatusOK
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			out := *u
+			pr.Out.URL = &out
+			pr.Out.Host = "" // send the backend's own host name
+			pr.SetXForwarded()
+		},
+		Transport:     transport,
+		FlushInterval: -1, // stream responses as they arrive
+		ModifyResponse: func(resp *http.Response) error {
+			status = resp.StatusCode
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			ctx.logf("Proxy error for '%s': %v", target, err)
+			status = http.StatusBadGateway
+			http.Error(w, fmt.Sprintf("Bad Gateway: %s", err.Error()), status)
+		},
+	}
+	rp.ServeHTTP(w, r)
+	return status, nil
+}
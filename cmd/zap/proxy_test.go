@@ -0,0 +1,166 @@
+package zap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// proxyConfig returns a Config with a proxied shortcut 'p' pointing at backend.
+func proxyConfig(backend string) string {
+	return fmt.Sprintf(`
+p:
+  expand: %s
+  ssl_off: yes
+  proxy: yes
+  r:
+    expand: redirected
+    proxy: no
+`, strings.TrimPrefix(backend, "http://"))
+}
+
+func TestProxy(t *testing.T) {
+	Convey("Given a shortcut in proxy mode", t, func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Backend-Path", r.URL.Path)
+			w.Header().Set("X-Backend-Query", r.URL.RawQuery)
+			w.Header().Set("X-Backend-Forwarded-Host", r.Header.Get("X-Forwarded-Host"))
+			w.WriteHeader(http.StatusTeapot)
+			_, _ = io.WriteString(w, "hello from backend")
+		}))
+		defer backend.Close()
+
+		c, err := parseYamlString(proxyConfig(backend.URL))
+		So(err, ShouldBeNil)
+		handler := http.Handler(&CtxWrapper{NewContext(c), IndexHandler})
+
+		Convey("When we GET http://p/some/path?x=1", func() {
+			req := httptest.NewRequest("GET", "/some/path?x=1", nil)
+			req.Host = "p"
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The backend response should be streamed back as-is", func() {
+				So(rr.Code, ShouldEqual, http.StatusTeapot)
+				So(rr.Body.String(), ShouldEqual, "hello from backend")
+				So(rr.Header().Get("X-Backend-Path"), ShouldEqual, "/some/path")
+				So(rr.Header().Get("X-Backend-Query"), ShouldEqual, "x=1")
+				So(rr.Header().Get("X-Backend-Forwarded-Host"), ShouldEqual, "p")
+			})
+		})
+
+		Convey("When a descendant turns proxy mode off", func() {
+			req := httptest.NewRequest("GET", "/r", nil)
+			req.Host = "p"
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a regular redirect", func() {
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, backend.URL+"/redirected")
+			})
+		})
+	})
+
+	Convey("Given a proxied shortcut", t, func() {
+		// The backend answers with the status code in the path, e.g. /418.
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+			w.WriteHeader(code)
+		}))
+		defer backend.Close()
+
+		c, err := parseYamlString(proxyConfig(backend.URL))
+		So(err, ShouldBeNil)
+		var reported int
+		handler := http.Handler(&CtxWrapper{NewContext(c), func(ctx *Context, w http.ResponseWriter, r *http.Request) (int, error) {
+			status, err := IndexHandler(ctx, w, r)
+			reported = status
+			return status, err
+		}})
+
+		for _, code := range []int{http.StatusTeapot, http.StatusInternalServerError} {
+			Convey(fmt.Sprintf("A %d from the backend should be reported as %d", code, code), func() {
+				req := httptest.NewRequest("GET", fmt.Sprintf("/%d", code), nil)
+				req.Host = "p"
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				So(rr.Code, ShouldEqual, code)
+				So(reported, ShouldEqual, code)
+			})
+		}
+	})
+
+	Convey("Given a proxied shortcut whose backend is down", t, func() {
+		backend := httptest.NewServer(http.NotFoundHandler())
+		backend.Close()
+
+		c, err := parseYamlString(proxyConfig(backend.URL))
+		So(err, ShouldBeNil)
+		handler := http.Handler(&CtxWrapper{NewContext(c), IndexHandler})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = "p"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		Convey("The result should be a 502", func() {
+			So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		})
+	})
+
+	Convey("Given a proxied shortcut to a websocket backend", t, func() {
+		// A minimal upgrade handshake followed by an echo, enough to check that the
+		// proxy hands over the raw connection in both directions.
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") != "websocket" {
+				http.Error(w, "expected upgrade", http.StatusBadRequest)
+				return
+			}
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+			_ = buf.Flush()
+			line, _ := buf.ReadString('\n')
+			_, _ = buf.WriteString("echo: " + line)
+			_ = buf.Flush()
+		}))
+		defer backend.Close()
+
+		c, err := parseYamlString(proxyConfig(backend.URL))
+		So(err, ShouldBeNil)
+		zap := httptest.NewServer(&CtxWrapper{NewContext(c), IndexHandler})
+		defer zap.Close()
+
+		conn, err := net.Dial("tcp", strings.TrimPrefix(zap.URL, "http://"))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: p\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		So(err, ShouldBeNil)
+
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		So(err, ShouldBeNil)
+
+		Convey("The upgrade should be passed through and the connection relayed", func() {
+			So(resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+			_, err = io.WriteString(conn, "ping\n")
+			So(err, ShouldBeNil)
+			line, err := br.ReadString('\n')
+			So(err, ShouldBeNil)
+			So(line, ShouldEqual, "echo: ping\n")
+		})
+	})
+}
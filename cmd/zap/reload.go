@@ -0,0 +1,56 @@
+package zap
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// reloadResponse is the JSON body returned by the reload endpoint.
+type reloadResponse struct {
+	// Status is "ok" if the new config is being served, "error" if the old one was kept.
+	Status string `json:"status"`
+
+	// Config is the file that was loaded.
+	Config string `json:"config"`
+
+	// Errors lists every problem found in the config, one per entry.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ReloadHandler rereads the config file on POST /_zap/reload. Requests must carry the reload
+// token as "Authorization: Bearer <token>". If the new config is invalid the old one keeps
+// being served, and the errors are returned with status 422.
+func ReloadHandler(ctx *Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	if ctx.ReloadToken == "" || ctx.ConfigFile == "" {
+		return http.StatusNotImplemented, fmt.Errorf("reload endpoint is not enabled, set a reload token")
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(ctx.ReloadToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="zap"`)
+		return http.StatusUnauthorized, fmt.Errorf("missing or invalid reload token")
+	}
+
+	resp := reloadResponse{Status: "ok", Config: ctx.ConfigFile}
+	if err := Reload(ctx, ctx.ConfigFile); err != nil {
+		ctx.logf("Error loading new Config: %s. Fallback to old Config.", err)
+		resp.Status = "error"
+		var merr *multierror.Error
+		if errors.As(err, &merr) {
+			for _, e := range merr.Errors {
+				resp.Errors = append(resp.Errors, e.Error())
+			}
+		} else {
+			resp.Errors = []string{err.Error()}
+		}
+		return writeJSON(w, http.StatusUnprocessableEntity, resp)
+	}
+
+	ctx.logf("Configuration reloaded successfully")
+	return writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,89 @@
+package zap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReloadHandler(t *testing.T) {
+	t.Setenv("ZAP_DISABLE_HOSTS_UPDATE", "1")
+
+	Convey("Given a handler with a reload token", t, func() {
+		useMemFs(t)
+		So(Afero.WriteFile("c.yml", []byte(cYaml), 0644), ShouldBeNil)
+		handler := NewHandler(WithConfigFile("c.yml"), WithReloadToken("s3cret"))
+
+		reload := func(token string) (*httptest.ResponseRecorder, reloadResponse) {
+			req := httptest.NewRequest("POST", DirectoryPath+"reload", nil)
+			req.Host = "localhost"
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			var resp reloadResponse
+			_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+			return rr, resp
+		}
+		location := func() string {
+			req := httptest.NewRequest("GET", "/z", nil)
+			req.Host = "g"
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			return rr.Header().Get("Location")
+		}
+
+		Convey("A valid config should be swapped in", func() {
+			So(Afero.WriteFile("c.yml", []byte("g:\n  expand: gitlab.com\n"), 0644), ShouldBeNil)
+			rr, resp := reload("s3cret")
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(resp, ShouldResemble, reloadResponse{Status: "ok", Config: "c.yml"})
+			So(location(), ShouldEqual, "https://gitlab.com/z")
+		})
+
+		Convey("An invalid config should be reported and the old one kept", func() {
+			So(Afero.WriteFile("c.yml", []byte(badValuesYAML), 0644), ShouldBeNil)
+			rr, resp := reload("s3cret")
+			So(rr.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(resp.Status, ShouldEqual, "error")
+			So(len(resp.Errors), ShouldBeGreaterThan, 1)
+			So(resp.Errors[0], ShouldStartWith, "c.yml:")
+			So(location(), ShouldEqual, "https://github.com/issmirnov/zap")
+		})
+
+		Convey("A missing config file should be reported", func() {
+			So(Afero.Remove("c.yml"), ShouldBeNil)
+			rr, resp := reload("s3cret")
+			So(rr.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(resp.Errors, ShouldHaveLength, 1)
+		})
+
+		Convey("Requests without the right token should be rejected", func() {
+			rr, _ := reload("")
+			So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+			So(rr.Header().Get("WWW-Authenticate"), ShouldStartWith, "Bearer")
+
+			rr, _ = reload("guess")
+			So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+
+	Convey("Given a handler without a reload token", t, func() {
+		useMemFs(t)
+		So(Afero.WriteFile("c.yml", []byte(cYaml), 0644), ShouldBeNil)
+		handler := NewHandler(WithConfigFile("c.yml"))
+
+		Convey("The endpoint should be disabled", func() {
+			req := httptest.NewRequest("POST", DirectoryPath+"reload", nil)
+			req.Host = "localhost"
+			req.Header.Set("Authorization", "Bearer ")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			So(rr.Code, ShouldEqual, http.StatusNotImplemented)
+		})
+	})
+}
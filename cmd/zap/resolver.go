@@ -0,0 +1,147 @@
+package zap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrNotFound is matched by errors.Is when a shortcut does not exist in the config.
+var ErrNotFound = errors.New("shortcut not found")
+
+// Resolver expands shortcuts into URLs with the same semantics as the zap server: schema and
+// ssl_off handling, expansions, placeholders, query encoding and the incoming query string.
+// The zero value is not usable, Config must be set.
+type Resolver struct {
+	// Config is the compiled shortcut tree, as returned by ParseYaml.
+	Config *Node
+
+	// Trace records the action taken for every token in Result.Steps and Result.Leftover.
+	Trace bool
+}
+
+// Result is the outcome of resolving a shortcut.
+type Result struct {
+	// URL is the expanded target, including the incoming query string unless the
+	// matched node sets drop_query.
+	URL string `json:"url"`
+
+	// Node is the dotted path of the deepest node matched, e.g. "g.s".
+	Node string `json:"node"`
+
+	// Schema is the effective schema, e.g. "https" or "chrome".
+	Schema string `json:"schema"`
+
+	// Status is the redirect status code, Proxy is set if the request should be proxied instead.
+	Status int  `json:"status"`
+	Proxy  bool `json:"proxy"`
+
+	// Steps lists the action taken for every token, Leftover the tokens that matched no node.
+	// Both are only filled in when tracing, Leftover is then never nil.
+	Steps    []TraceStep `json:"steps"`
+	Leftover []string    `json:"leftover"`
+}
+
+// NewResolver returns a Resolver for the given config.
+func NewResolver(c *Node) *Resolver {
+	return &Resolver{Config: c}
+}
+
+// Resolve expands a shortcut given as host (the top-level key), path and raw query string,
+// e.g. "g", "/s/foo" and "tab=readme". Hosts are case insensitive, as in DNS. Returns an
+// error matching ErrNotFound for unknown hosts.
+func (r *Resolver) Resolve(host, path, rawQuery string) (Result, error) {
+	conf := r.Config
+	if conf == nil {
+		return Result{}, fmt.Errorf("configuration not loaded or invalid")
+	}
+
+	key, hostConfig, ok := conf.lookupHost(host)
+	if !ok {
+		return Result{}, newNotFoundError(conf, listValues(tokenize(host+path)), 0)
+	}
+	host = key
+	tokens := tokenize(host + path)
+
+	// Set up handles on token and Config. We might need to skip ahead if there's a custom schema set.
+	tokensStart := tokens.Front()
+
+	var res bytes.Buffer
+	e := &expansion{res: &res, tracing: r.Trace}
+	schema := "https"
+	if hostConfig.SSLOff {
+		res.WriteString(httpPrefix)
+		schema = "http"
+	} else if hostConfig.Schema != "" {
+		res.WriteString(hostConfig.Schema + ":/")
+		schema = hostConfig.Schema
+		// move one token ahead to parse expansions correctly.
+		conf = hostConfig
+		e.record(host, hostConfig, schemaKey, nil, res.Len())
+		tokensStart = tokensStart.Next()
+	} else {
+		// Default to regular https prefix.
+		res.WriteString(httpsPrefix)
+	}
+
+	e.last = conf
+	if err := e.expandPath(conf, tokensStart, true); err != nil {
+		return Result{}, fmt.Errorf("failed to expand path for host '%s': %w", host, err)
+	}
+	if e.miss != nil && len(typos(e.miss.Value.(string), sortedKeys(e.missNode.Children))) > 0 {
+		i := 0
+		for t := tokens.Front(); t != e.miss; t = t.Next() {
+			i++
+		}
+		return Result{}, newNotFoundError(e.missNode, listValues(tokens), i)
+	}
+
+	// Validate that we generated a valid path
+	if res.Len() == 0 {
+		return Result{}, fmt.Errorf("failed to generate redirect path for host '%s'", host)
+	}
+
+	// Carry the incoming query string over, unless the matched shortcut opts out.
+	// Fragments never reach the server; browsers re-apply them to the redirect target.
+	target := res.String()
+	if !e.last.dropQuery {
+		target = appendQuery(target, rawQuery)
+	}
+
+	if r.Trace && e.leftover == nil {
+		e.leftover = []string{}
+	}
+
+	return Result{
+		URL:      target,
+		Node:     e.last.path,
+		Schema:   schema,
+		Status:   e.last.redirectStatus(),
+		Proxy:    e.last.proxy,
+		Steps:    e.steps,
+		Leftover: e.leftover,
+	}, nil
+}
+
+// ResolveShortcut resolves a shortcut as typed into the browser, e.g. "g/s/foo?x=1".
+func (r *Resolver) ResolveShortcut(s string) (Result, error) {
+	shortcut, rawQuery, _ := strings.Cut(s, "?")
+	host, path, _ := strings.Cut(shortcut, "/")
+	return r.Resolve(host, "/"+path, rawQuery)
+}
+
+// ResolveRequest resolves an incoming HTTP request, honoring X-Forwarded-Host.
+func (r *Resolver) ResolveRequest(req *http.Request) (Result, error) {
+	return r.Resolve(requestHost(req), req.URL.Path, req.URL.RawQuery)
+}
+
+// requestHost returns the shortcut host of a request, preferring X-Forwarded-Host
+// so that zap works behind nginx and other reverse proxies.
+func requestHost(r *http.Request) string {
+	if h := r.Header.Get("X-Forwarded-Host"); h != "" {
+		return h
+	}
+	return r.Host
+}
@@ -0,0 +1,96 @@
+package zap
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResolver(t *testing.T) {
+	Convey("Given a resolver for the default Config", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		r := NewResolver(c)
+
+		Convey("It should expand a host, path and query", func() {
+			res, err := r.Resolve("g", "/z", "tab=readme")
+			So(err, ShouldBeNil)
+			So(res.URL, ShouldEqual, "https://github.com/issmirnov/zap?tab=readme")
+			So(res.Node, ShouldEqual, "g.z")
+			So(res.Schema, ShouldEqual, "https")
+			So(res.Status, ShouldEqual, http.StatusFound)
+			So(res.Proxy, ShouldBeFalse)
+			So(res.Steps, ShouldBeNil)
+		})
+
+		Convey("It should apply ssl_off and custom schemas", func() {
+			res, err := r.Resolve("z", "/", "")
+			So(err, ShouldBeNil)
+			So(res.Schema, ShouldEqual, "http")
+
+			res, err = r.Resolve("ch", "/v", "")
+			So(err, ShouldBeNil)
+			So(res.URL, ShouldEqual, "chrome://version")
+			So(res.Schema, ShouldEqual, "chrome")
+		})
+
+		Convey("It should report inherited settings of the deepest node", func() {
+			res, err := r.Resolve("e", "/a", "")
+			So(err, ShouldBeNil)
+			So(res.Status, ShouldEqual, http.StatusTemporaryRedirect)
+
+			res, err = r.Resolve("ak", "/hi", "x=1")
+			So(err, ShouldBeNil)
+			So(res.URL, ShouldNotContainSubstring, "x=1")
+		})
+
+		Convey("It should match hosts regardless of case", func() {
+			res, err := r.Resolve("G", "/z", "")
+			So(err, ShouldBeNil)
+			So(res.URL, ShouldEqual, "https://github.com/issmirnov/zap")
+			So(res.Node, ShouldEqual, "g.z")
+		})
+
+		Convey("It should return ErrNotFound for unknown hosts", func() {
+			_, err := r.Resolve("gh", "/z", "")
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+		})
+
+		Convey("It should fail without a config", func() {
+			_, err := (&Resolver{}).Resolve("g", "/", "")
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, ErrNotFound), ShouldBeFalse)
+		})
+
+		Convey("It should parse shortcuts as typed into the browser", func() {
+			res, err := r.ResolveShortcut("g/s/foo?tab=1")
+			So(err, ShouldBeNil)
+			So(res.URL, ShouldEqual, "https://github.com/search?q=foo&tab=1")
+		})
+
+		Convey("It should read the host from requests, preferring X-Forwarded-Host", func() {
+			req := httptest.NewRequest("GET", "/z", nil)
+			req.Host = "g"
+			res, err := r.ResolveRequest(req)
+			So(err, ShouldBeNil)
+			So(res.URL, ShouldEqual, "https://github.com/issmirnov/zap")
+
+			req.Host = "zap.example.com"
+			req.Header.Set("X-Forwarded-Host", "e")
+			res, err = r.ResolveRequest(req)
+			So(err, ShouldBeNil)
+			So(res.URL, ShouldEqual, "https://example.com/z")
+		})
+
+		Convey("With tracing it should record steps and leftover tokens", func() {
+			r.Trace = true
+			res, err := r.Resolve("g", "/s/foo", "")
+			So(err, ShouldBeNil)
+			So(res.Steps, ShouldHaveLength, 3)
+			So(res.Leftover, ShouldResemble, []string{"foo"})
+		})
+	})
+}
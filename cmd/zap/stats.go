@@ -0,0 +1,216 @@
+package zap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultTopN is the number of shortcuts in a usage report unless asked otherwise.
+const defaultTopN = 10
+
+// Usage is how often a shortcut was used, and when it was last used.
+type Usage struct {
+	Hits     uint64    `json:"hits"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Stats counts hits per node path, e.g. "g.s". It is safe for concurrent use, and a nil
+// *Stats records nothing.
+type Stats struct {
+	mu    sync.Mutex
+	usage map[string]*Usage
+	dirty bool
+}
+
+// NewStats returns empty usage statistics.
+func NewStats() *Stats {
+	return &Stats{usage: make(map[string]*Usage)}
+}
+
+// LoadStats reads statistics saved by Save. A missing file yields empty statistics.
+func LoadStats(fname string) (*Stats, error) {
+	s := NewStats()
+	data, err := Afero.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats file '%s': %w", fname, err)
+	}
+	if err := json.Unmarshal(data, &s.usage); err != nil {
+		return nil, fmt.Errorf("failed to parse stats file '%s': %w", fname, err)
+	}
+	if s.usage == nil {
+		s.usage = make(map[string]*Usage)
+	}
+	return s, nil
+}
+
+// Record counts a hit on the node at path.
+func (s *Stats) Record(path string, t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.usage[path]
+	if !ok {
+		u = &Usage{}
+		s.usage[path] = u
+	}
+	u.Hits++
+	if t.After(u.LastUsed) {
+		u.LastUsed = t
+	}
+	s.dirty = true
+}
+
+// Usage returns a copy of the statistics, keyed by node path.
+func (s *Stats) Usage() map[string]Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]Usage, len(s.usage))
+	for k, u := range s.usage {
+		out[k] = *u
+	}
+	return out
+}
+
+// Save writes the statistics to fname as JSON. The file is replaced atomically, so a
+// crash never leaves it half written. Nothing is written if there are no new hits.
+func (s *Stats) Save(fname string) error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s.usage, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode stats: %w", err)
+	}
+
+	if err := writeFileAtomic(fname, append(data, '\n'), 0644); err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run saves the statistics to fname every interval, and once more when ctx is done.
+// Errors are passed to logf.
+func (s *Stats) Run(ctx context.Context, fname string, interval time.Duration, logf func(string, ...interface{})) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err := s.Save(fname); err != nil {
+				logf("Failed to save usage stats: %v", err)
+			}
+			return
+		}
+		if err := s.Save(fname); err != nil {
+			logf("Failed to save usage stats: %v", err)
+		}
+	}
+}
+
+// ShortcutUsage is a row in a usage report.
+type ShortcutUsage struct {
+	// Node is the dotted path of the node, e.g. "g.s".
+	Node string `json:"node"`
+	Usage
+}
+
+// UsageReport lists the most used shortcuts, and the ones never used.
+type UsageReport struct {
+	// Top are the most used nodes, most hits first.
+	Top []ShortcutUsage `json:"top"`
+
+	// Unused are the nodes in the config that were never used, neither directly nor through
+	// a node below them. Nodes below an unused node are not listed separately.
+	Unused []string `json:"unused"`
+}
+
+// Report returns the n most used shortcuts, and the shortcuts of conf that were never used.
+func (s *Stats) Report(conf *Node, n int) UsageReport {
+	usage := s.Usage()
+	report := UsageReport{Top: []ShortcutUsage{}, Unused: []string{}}
+
+	for k, u := range usage {
+		report.Top = append(report.Top, ShortcutUsage{Node: k, Usage: u})
+	}
+	sort.Slice(report.Top, func(i, j int) bool {
+		a, b := report.Top[i], report.Top[j]
+		if a.Hits != b.Hits {
+			return a.Hits > b.Hits
+		}
+		return a.Node < b.Node
+	})
+	if n >= 0 && len(report.Top) > n {
+		report.Top = report.Top[:n]
+	}
+
+	if conf != nil {
+		for _, k := range sortedKeys(conf.Children) {
+			report.Unused = appendUnused(report.Unused, conf.Children[k], usage)
+		}
+	}
+	return report
+}
+
+// appendUnused appends the path of n if neither it nor any node below it was used,
+// otherwise it looks for unused nodes further down.
+func appendUnused(unused []string, n *Node, usage map[string]Usage) []string {
+	if !subtreeUsed(n, usage) {
+		return append(unused, n.path)
+	}
+	for _, k := range sortedKeys(n.Children) {
+		unused = appendUnused(unused, n.Children[k], usage)
+	}
+	if n.Wildcard != nil {
+		unused = appendUnused(unused, n.Wildcard, usage)
+	}
+	return unused
+}
+
+// subtreeUsed reports whether n or any node below it has hits.
+func subtreeUsed(n *Node, usage map[string]Usage) bool {
+	if _, ok := usage[n.path]; ok {
+		return true
+	}
+	for _, child := range n.Children {
+		if subtreeUsed(child, usage) {
+			return true
+		}
+	}
+	return n.Wildcard != nil && subtreeUsed(n.Wildcard, usage)
+}
+
+// StatsHandler responds to /_zap/stats?n=10 with a JSON UsageReport.
+func StatsHandler(ctx *Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	if ctx.Stats == nil {
+		return http.StatusNotImplemented, fmt.Errorf("usage statistics are not enabled")
+	}
+
+	n := defaultTopN
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid 'n' parameter '%s', expected a number", v)
+		}
+	}
+	return writeJSON(w, http.StatusOK, ctx.Stats.Report(ctx.Config(), n))
+}
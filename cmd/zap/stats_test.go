@@ -0,0 +1,161 @@
+package zap
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStats(t *testing.T) {
+	Convey("Given usage stats", t, func() {
+		s := NewStats()
+		t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		s.Record("g.s", t0)
+		s.Record("g.s", t0.Add(time.Hour))
+		s.Record("g.z", t0)
+		s.Record("e.a", t0)
+
+		Convey("It should count hits and keep the latest use", func() {
+			u := s.Usage()
+			So(u["g.s"], ShouldResemble, Usage{Hits: 2, LastUsed: t0.Add(time.Hour)})
+			So(u["g.z"].Hits, ShouldEqual, 1)
+		})
+
+		Convey("A nil Stats should record nothing", func() {
+			var none *Stats
+			So(func() { none.Record("g", t0) }, ShouldNotPanic)
+		})
+
+		Convey("The report should list top shortcuts and whole unused subtrees", func() {
+			conf, err := parseYamlString(`
+g:
+  expand: github.com
+  s:
+    query: "search?q="
+  z:
+    expand: issmirnov/zap
+  d:
+    expand: issmirnov/dotfiles
+e:
+  expand: example.com
+  a:
+    expand: apples
+f:
+  expand: facebook.com
+  p:
+    expand: php
+`)
+			So(err, ShouldBeNil)
+
+			report := s.Report(conf, 2)
+			So(report.Top, ShouldHaveLength, 2)
+			So(report.Top[0].Node, ShouldEqual, "g.s")
+			So(report.Top[0].Hits, ShouldEqual, 2)
+			So(report.Top[1].Node, ShouldEqual, "e.a")
+			So(report.Unused, ShouldResemble, []string{"f", "g.d"})
+		})
+
+		Convey("It should survive a save and load", func() {
+			useMemFs(t)
+			So(s.Save("/var/lib/zap/stats.json"), ShouldBeNil)
+
+			loaded, err := LoadStats("/var/lib/zap/stats.json")
+			So(err, ShouldBeNil)
+			So(loaded.Usage(), ShouldResemble, s.Usage())
+
+			files, err := Afero.ReadDir("/var/lib/zap")
+			So(err, ShouldBeNil)
+			So(files, ShouldHaveLength, 1)
+		})
+
+		Convey("Saving should be skipped without new hits", func() {
+			useMemFs(t)
+			So(s.Save("stats.json"), ShouldBeNil)
+			So(Afero.Remove("stats.json"), ShouldBeNil)
+			So(s.Save("stats.json"), ShouldBeNil)
+
+			exists, _ := Afero.Exists("stats.json")
+			So(exists, ShouldBeFalse)
+		})
+
+		Convey("Run should save when it is stopped", func() {
+			useMemFs(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				s.Run(ctx, "stats.json", time.Hour, t.Logf)
+				close(done)
+			}()
+			cancel()
+			<-done
+
+			exists, _ := Afero.Exists("stats.json")
+			So(exists, ShouldBeTrue)
+		})
+	})
+
+	Convey("Loading a missing stats file should start from scratch", t, func() {
+		useMemFs(t)
+		s, err := LoadStats("missing.json")
+		So(err, ShouldBeNil)
+		So(s.Usage(), ShouldBeEmpty)
+
+		So(Afero.WriteFile("bad.json", []byte("nope"), 0644), ShouldBeNil)
+		_, err = LoadStats("bad.json")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestStatsHandler(t *testing.T) {
+	Convey("Given a handler recording usage stats", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		stats := NewStats()
+		handler := NewHandler(WithConfig(c), WithStats(stats))
+
+		get := func(host, path string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Host = host
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			return rr
+		}
+
+		Convey("Redirects should be counted by node path", func() {
+			get("g", "/s/foo/bar")
+			get("g", "/s/baz")
+			get("ak", "/23/j")
+			get("nope", "/x")
+
+			rr := get("localhost", DirectoryPath+"stats?n=1")
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			var report UsageReport
+			So(json.Unmarshal(rr.Body.Bytes(), &report), ShouldBeNil)
+			So(report.Top, ShouldHaveLength, 1)
+			So(report.Top[0].Node, ShouldEqual, "g.s")
+			So(report.Top[0].Hits, ShouldEqual, 2)
+			So(stats.Usage(), ShouldContainKey, "ak.*.j")
+			So(report.Unused, ShouldContain, "e")
+			So(report.Unused, ShouldNotContain, "g")
+		})
+
+		Convey("A bad n should be rejected", func() {
+			So(get("localhost", DirectoryPath+"stats?n=x").Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+
+	Convey("Given a handler without usage stats", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", DirectoryPath+"stats", nil)
+		req.Host = "localhost"
+		NewHandler(WithConfig(c)).ServeHTTP(rr, req)
+		So(rr.Code, ShouldEqual, http.StatusNotImplemented)
+	})
+}
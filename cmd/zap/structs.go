@@ -1,22 +1,310 @@
 package zap
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/netip"
+	"strings"
 	"sync"
-
-	"github.com/Jeffail/gabs/v2"
+	"sync/atomic"
+	"time"
 )
 
-type Context struct {
-	// Config is a Json container with path configs
-	Config *gabs.Container
+// Node is a single entry in the compiled shortcut tree. Reserved keys from the
+// config file are lifted into typed fields, all other keys become Children.
+type Node struct {
+	// Expand is written in place of the matched token. It may contain positional
+	// placeholders ({1}, {2}, ...) which are filled with the following tokens.
+	Expand string
+
+	// Query is written like Expand, but suppresses the slash before the next token.
+	// Tokens that follow a query are percent-encoded and joined with QuerySep.
+	Query string
+
+	// QuerySep joins multiple tokens after a query, "/" by default.
+	QuerySep string
+
+	// QueryRaw disables percent-encoding of the tokens after a query.
+	QueryRaw bool
+
+	// Port is appended as ":port" to the expansion so far.
+	Port int
+
+	// Schema overrides the default https schema. Only honored on top-level nodes.
+	Schema string
+
+	// SSLOff switches the schema to plain http. Only honored on top-level nodes.
+	SSLOff bool
+
+	// DropQuery discards the query string of the incoming request instead of forwarding
+	// it to the expanded URL. Inherited by descendants, nil when not set on this node.
+	DropQuery *bool
+
+	// Status is the HTTP redirect status code, one of 301, 302, 303, 307 or 308.
+	// Inherited by descendants, 0 when not set on this node.
+	Status int
+
+	// Proxy makes zap fetch the expanded URL and stream the response back, instead of
+	// redirecting. Inherited by descendants, nil when not set on this node.
+	Proxy *bool
+
+	// Children maps path tokens to nested shortcuts.
+	Children map[string]*Node
+
+	// Wildcard matches any token that is not present in Children ("*" in the config).
+	Wildcard *Node
+
+	// action is one of expand, query, port or none, resolved at compile time.
+	action int
+
+	// prefix is the precomputed string written to the result when this node matches.
+	prefix string
+
+	// template holds Expand split on its {N} placeholders, nil if there are none.
+	template []templatePart
+
+	// args is the number of tokens consumed by the placeholders in template.
+	args int
+
+	// dropQuery is the effective DropQuery setting, after inheritance.
+	dropQuery bool
+
+	// status is the effective Status setting, after inheritance.
+	status int
+
+	// proxy is the effective Proxy setting, after inheritance.
+	proxy bool
+
+	// path is the dotted key path of this node, e.g. "g.s".
+	path string
+
+	// pos is where this node's key is defined in the config file.
+	pos position
+
+	// valuePos holds where the values of reserved keys are defined, for errors found after
+	// parsing. Keys without an entry are reported at pos.
+	valuePos map[string]position
+}
+
+// redirectStatus returns the status code used when redirecting to this node.
+func (n *Node) redirectStatus() int {
+	if n.status == 0 {
+		return http.StatusFound
+	}
+	return n.status
+}
+
+// lookupHost returns the top-level shortcut named host, and its key. Host names are case
+// insensitive, so an exact match wins, then any key that only differs in case.
+func (n *Node) lookupHost(host string) (string, *Node, bool) {
+	if child, ok := n.Children[host]; ok {
+		return host, child, true
+	}
+	for k, child := range n.Children {
+		if strings.EqualFold(k, host) {
+			return k, child, true
+		}
+	}
+	return "", nil, false
+}
+
+// position is a location in a config file.
+type position struct {
+	file         string
+	line, column int
+}
+
+// errorf returns a ConfigError located at the value of the given reserved key, or at this
+// node if its position wasn't recorded.
+func (n *Node) errorf(key string, format string, args ...interface{}) *ConfigError {
+	pos, ok := n.valuePos[key]
+	if !ok {
+		pos = n.pos
+	}
+	return &ConfigError{
+		File:   pos.file,
+		Line:   pos.line,
+		Column: pos.column,
+		Path:   joinPath(n.path, key),
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// ConfigError is a problem found in the config file. Its message uses the
+// "file:line:col: key.path: message" format understood by most editors.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+
+	// Path is the dotted key path of the offending entry, e.g. "a.s.query".
+	Path string
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(":")
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "%d:", e.Line)
+		if e.Column > 0 {
+			fmt.Fprintf(&b, "%d:", e.Column)
+		}
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Msg)
+	return b.String()
+}
 
-	// ConfigMtx Enables safe hot reloading of Config.
-	ConfigMtx sync.Mutex
+// MarshalJSON renders the node back into the shape used by the config file.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(n.Children)+1)
+	for k, v := range n.Children {
+		m[k] = v
+	}
+	if n.Wildcard != nil {
+		m[passKey] = n.Wildcard
+	}
+	switch n.action {
+	case expand:
+		m[expandKey] = n.Expand
+	case query:
+		m[queryKey] = n.Query
+		if n.QuerySep != defaultQuerySep {
+			m[querySepKey] = n.QuerySep
+		}
+		if n.QueryRaw {
+			m[queryRawKey] = true
+		}
+	case port:
+		m[portKey] = n.Port
+	}
+	if n.Schema != "" {
+		m[schemaKey] = n.Schema
+	}
+	if n.SSLOff {
+		m[sslKey] = true
+	}
+	if n.DropQuery != nil {
+		m[dropQueryKey] = *n.DropQuery
+	}
+	if n.Status != 0 {
+		m[statusKey] = n.Status
+	}
+	if n.Proxy != nil {
+		m[proxyKey] = *n.Proxy
+	}
+	return json.Marshal(m)
+}
+
+type Context struct {
+	// config holds the compiled shortcut tree. It is swapped atomically on hot reload,
+	// so readers never block and always see a complete tree.
+	config atomic.Pointer[Node]
 
-	// Advertise IP, used in /etc/hosts in case bind address differs.
+	// Advertise IP, used in /etc/hosts in case bind address differs. May be a comma
+	// separated list, see ParseAddrs. Ignored if AdvertiseAddrs is set.
 	Advertise string
+
+	// AdvertiseAddrs holds the IPv4 and IPv6 addresses shortcut names resolve to, in the
+	// hosts file and DNS answers, in case they differ from the bind addresses.
+	AdvertiseAddrs []netip.Addr
+
+	// ProxyTransport is used for shortcuts in proxy mode. Defaults to DefaultProxyTransport.
+	ProxyTransport http.RoundTripper
+
+	// ConfigFile is the file the Config was loaded from, reread by the reload endpoint.
+	ConfigFile string
+
+	// ReloadToken authorizes POST requests to the reload endpoint. Empty disables the endpoint.
+	ReloadToken string
+
+	// reloadMu serializes reloads from the file watcher, signals and the reload endpoint.
+	reloadMu sync.Mutex
+
+	// HostsFile is kept in sync with the top-level shortcuts. Empty disables updates.
+	HostsFile string
+
+	// Logger receives reload, hosts file and proxy messages. Defaults to the standard logger.
+	Logger *log.Logger
+
+	// AccessLog logs every request, if set.
+	AccessLog *AccessLog
+
+	// Stats counts hits per shortcut, if set.
+	Stats *Stats
+
+	// metrics are nil unless a registry is configured with WithRegisterer.
+	metrics *metrics
+}
+
+// NewContext returns a Context serving the given Config.
+func NewContext(c *Node) *Context {
+	ctx := &Context{}
+	ctx.config.Store(c)
+	return ctx
+}
+
+// Config returns the current Config snapshot. Handlers should call this once per
+// request and use the result throughout, so that a concurrent reload cannot mix
+// two different configs within the same request.
+func (c *Context) Config() *Node {
+	return c.config.Load()
+}
+
+// SetConfig atomically replaces the Config. Requests already in flight keep
+// using the snapshot they loaded.
+func (c *Context) SetConfig(n *Node) {
+	c.config.Store(n)
+}
+
+// advertised returns AdvertiseAddrs, or the addresses in Advertise if it is empty. Invalid
+// entries in Advertise are skipped.
+func (c *Context) advertised() []netip.Addr {
+	if len(c.AdvertiseAddrs) > 0 || c.Advertise == "" {
+		return c.AdvertiseAddrs
+	}
+	var addrs []netip.Addr
+	for _, v := range strings.Split(c.Advertise, ",") {
+		if a, err := netip.ParseAddr(strings.TrimSpace(v)); err == nil {
+			addrs = append(addrs, a.Unmap())
+		}
+	}
+	return addrs
+}
+
+// logf logs to the Context logger, or the standard logger if none is set.
+func (c *Context) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// countRequest records a request in the metrics, labelled with its top-level shortcut if
+// the host is one.
+func (c *Context) countRequest(r *http.Request, status int) {
+	if c.metrics == nil {
+		return
+	}
+	var shortcut string
+	if conf := c.Config(); conf != nil {
+		shortcut, _, _ = conf.lookupHost(requestHost(r))
+	}
+	c.metrics.countRequest(status, shortcut)
 }
 
 type CtxWrapper struct {
@@ -25,7 +313,17 @@ type CtxWrapper struct {
 }
 
 func (cw CtxWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var entry *accessEntry
+	if cw.Context != nil {
+		entry, r = cw.AccessLog.begin(r)
+	}
+
 	status, err := cw.H(cw.Context, w, r) // this runs the actual handler, defined in struct.
+	if cw.Context != nil {
+		cw.countRequest(r, status)
+		cw.AccessLog.log(entry, r, status, time.Since(start))
+	}
 	if err != nil {
 		switch status {
 		case http.StatusInternalServerError:
@@ -33,6 +331,12 @@ func (cw CtxWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			errorMsg := fmt.Sprintf("Internal Server Error: %s", err.Error())
 			http.Error(w, errorMsg, status)
 		case http.StatusNotFound:
+			// Point the user at what they probably meant.
+			var nf *notFoundError
+			if errors.As(err, &nf) {
+				renderNotFound(w, r, nf)
+				return
+			}
 			// Provide helpful message for 404 errors
 			errorMsg := fmt.Sprintf("Shortcut not found: %s", err.Error())
 			http.Error(w, errorMsg, status)
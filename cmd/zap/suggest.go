@@ -0,0 +1,162 @@
+package zap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxSuggestions caps the number of "did you mean" entries on the 404 page.
+const maxSuggestions = 5
+
+// notFoundError is returned when a request's host is not a shortcut, or when a token below
+// it looks like a typo of a key at that level, see typos. Other unknown tokens are passed
+// through to the target. It carries enough context to point the user at what they
+// probably meant.
+type notFoundError struct {
+	// Message repeats Error() for JSON clients.
+	Message string `json:"error"`
+
+	// Shortcut is the requested shortcut, e.g. "gh/z".
+	Shortcut string `json:"shortcut"`
+
+	// Matched is the part of Shortcut that exists in the config, e.g. "" or "a".
+	Matched string `json:"matched"`
+
+	// Suggestions are existing shortcuts close to the requested one.
+	Suggestions []string `json:"suggestions"`
+
+	// Children are the valid keys below the deepest matched node.
+	Children []string `json:"children"`
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("shortcut '%s' not found in config", e.Shortcut)
+}
+
+// Is reports not found errors as ErrNotFound.
+func (e *notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// newNotFoundError suggests keys of n, the deepest matched node, that are close to
+// tokens[i], the first token that matched none of them, keeping the rest of the path.
+// n is the root for unknown hosts, with i 0.
+func newNotFoundError(n *Node, tokens []string, i int) *notFoundError {
+	// A trailing slash, as in "gh/", is not part of the shortcut as typed.
+	for len(tokens) > i+1 && tokens[len(tokens)-1] == "" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	nf := &notFoundError{Shortcut: strings.Join(tokens, "/"), Suggestions: []string{}}
+	nf.Message = nf.Error()
+	nf.Matched = strings.Join(tokens[:i], "/")
+	nf.Children = sortedKeys(n.Children)
+	if i >= len(tokens) {
+		return nf
+	}
+
+	prefix := ""
+	if i > 0 {
+		prefix = nf.Matched + "/"
+	}
+	rest := ""
+	if i+1 < len(tokens) {
+		rest = "/" + strings.Join(tokens[i+1:], "/")
+	}
+	for _, k := range closestKeys(tokens[i], nf.Children) {
+		nf.Suggestions = append(nf.Suggestions, prefix+k+rest)
+	}
+	return nf
+}
+
+// lookalikes maps characters that are easily mistaken for each other to one of them.
+var lookalikes = strings.NewReplacer("0", "o", "O", "o", "1", "l", "I", "l")
+
+// typos returns the keys tok is probably a mistyped version of: keys that only differ
+// from it in characters that look alike, such as "o" for "0". Below a known host, only
+// these get a not found page. Anything else may well be meant for the target, so it is
+// passed through.
+func typos(tok string, keys []string) []string {
+	var out []string
+	for _, k := range keys {
+		if k != tok && lookalikes.Replace(k) == lookalikes.Replace(tok) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// closestKeys returns the keys that are within a small edit distance of tok, or that
+// share a prefix with it, best matches first.
+func closestKeys(tok string, keys []string) []string {
+	type candidate struct {
+		key    string
+		dist   int
+		prefix bool
+	}
+
+	// Allow roughly one typo per three characters, and at least one.
+	limit := len(tok)/3 + 1
+
+	var candidates []candidate
+	for _, k := range keys {
+		d := editDistance(tok, k)
+		prefix := tok != "" && (strings.HasPrefix(k, tok) || strings.HasPrefix(tok, k))
+		if prefix && d > 1 {
+			// Prefix matches rank like single typos, however long the rest is.
+			d = 1
+		}
+		if d <= limit {
+			candidates = append(candidates, candidate{k, d, prefix})
+		}
+	}
+
+	// Closest first, prefix matches win ties, then lexical order.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].dist != candidates[j].dist {
+			return candidates[i].dist < candidates[j].dist
+		}
+		return candidates[i].prefix && !candidates[j].prefix
+	})
+	if len(candidates) > maxSuggestions {
+		candidates = candidates[:maxSuggestions]
+	}
+
+	out := make([]string, len(candidates))
+	for i, c := range candidates {
+		out[i] = c.key
+	}
+	return out
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys(m map[string]*Node) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,62 @@
+package zap
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEditDistance(t *testing.T) {
+	Convey("Given pairs of strings", t, func() {
+		So(editDistance("", ""), ShouldEqual, 0)
+		So(editDistance("g", ""), ShouldEqual, 1)
+		So(editDistance("o", "0"), ShouldEqual, 1)
+		So(editDistance("kitten", "sitting"), ShouldEqual, 3)
+		So(editDistance("gh", "g"), ShouldEqual, 1)
+	})
+}
+
+func TestNotFoundSuggestions(t *testing.T) {
+	Convey("Given the default Config", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+
+		Convey("An unknown host should suggest close top-level shortcuts and keep the path", func() {
+			nf := newNotFoundError(c, []string{"gh", "z"}, 0)
+			So(nf.Shortcut, ShouldEqual, "gh/z")
+			So(nf.Matched, ShouldEqual, "")
+			So(nf.Suggestions, ShouldContain, "g/z")
+			So(nf.Suggestions, ShouldContain, "gp/z")
+			So(nf.Children, ShouldContain, "ak")
+			So(len(nf.Suggestions), ShouldBeLessThanOrEqualTo, maxSuggestions)
+		})
+
+		Convey("A bare unknown host should be reported and suggested without a slash", func() {
+			nf := newNotFoundError(c, []string{"gh", ""}, 0)
+			So(nf.Shortcut, ShouldEqual, "gh")
+			So(nf.Error(), ShouldEqual, "shortcut 'gh' not found in config")
+			So(nf.Suggestions, ShouldContain, "g")
+			So(nf.Suggestions, ShouldNotContain, "g/")
+		})
+
+		Convey("A typo below a known shortcut should list the keys at that level", func() {
+			nf := newNotFoundError(c.Children["g"], []string{"g", "zz", "x"}, 1)
+			So(nf.Shortcut, ShouldEqual, "g/zz/x")
+			So(nf.Matched, ShouldEqual, "g")
+			So(nf.Suggestions[0], ShouldEqual, "g/z/x")
+			So(nf.Children, ShouldResemble, []string{"d", "pr", "s", "sp", "sr", "z"})
+		})
+
+		Convey("Only keys with lookalike characters should count as typos", func() {
+			So(typos("0", []string{"c", "o", "oo"}), ShouldResemble, []string{"o"})
+			So(typos("w1ki", []string{"wiki", "wlki"}), ShouldResemble, []string{"wlki"})
+			So(typos("z", []string{"a", "b", "z"}), ShouldBeEmpty)
+			So(typos("zz", []string{"z", "d"}), ShouldBeEmpty)
+		})
+
+		Convey("A completely different token should not produce suggestions", func() {
+			nf := newNotFoundError(c, []string{"completelyunrelated"}, 0)
+			So(nf.Suggestions, ShouldBeEmpty)
+		})
+	})
+}
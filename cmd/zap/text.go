@@ -4,13 +4,15 @@ import (
 	"bytes"
 	"container/list"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
-
-	"github.com/Jeffail/gabs/v2"
 )
 
 const (
-	expand = iota
+	none = iota
+	expand
 	query
 	port
 )
@@ -27,52 +29,109 @@ func tokenize(path string) *list.List {
 	return l
 }
 
-// Returns string to write to result, boolean flag indicating whether to advance
-// token, and error if needed.
-// The option to advance the token is needed when we want to suppress the slash separator.
-func getPrefix(c *gabs.Container) (string, int, error) {
-	d := c.Path(expandKey).Data()
-	if d != nil {
-		s, oks := d.(string)
-		i, oki := d.(float64)
-		if oks {
-			return s, expand, nil
-		} else if oki {
-			return fmt.Sprintf("%.f", i), expand, nil
-		}
-		return "", 0, fmt.Errorf("unexpected type of expansion value, got %T instead of int or string", d)
+// listValues returns the tokens of a list as a slice.
+func listValues(l *list.List) []string {
+	out := make([]string, 0, l.Len())
+	for e := l.Front(); e != nil; e = e.Next() {
+		out = append(out, e.Value.(string))
 	}
+	return out
+}
 
-	q := c.Path(queryKey).Data()
-	if q != nil {
-		if s, ok := q.(string); ok {
-			return s, query, nil
-		}
-		return "", 0, fmt.Errorf("casting query key to string failed for %T:%v", q, q)
+// ExpandPath takes a Config, list of tokens (parsed from request) and the results buffer
+// At each level of recursion, it matches the token to the action described in the Config, and writes it
+// to the result buffer. There is special care needed to handle slashes correctly, which makes this function
+// quite nontrivial. Tests are crucial to ensure correctness.
+func ExpandPath(c *Node, token *list.Element, res *bytes.Buffer) error {
+	e := &expansion{res: res}
+	return e.expandPath(c, token, true)
+}
+
+// expansion carries the state of a single ExpandPath call through the recursion.
+type expansion struct {
+	res *bytes.Buffer
+
+	// query is the closest query node matched so far, nil while still in the path part.
+	// User supplied tokens written after it are query values.
+	query *Node
+
+	// last is the deepest node matched so far. Inherited settings are read from it.
+	last *Node
+
+	// miss is the first token that matched no key of missNode, a node with children, outside
+	// of a query. The resolver checks whether it is a typo.
+	miss     *list.Element
+	missNode *Node
+
+	// tracing enables recording of steps and leftover, used by the preview API.
+	tracing  bool
+	steps    []TraceStep
+	leftover []string
+}
+
+// TraceStep describes what the expansion did with a single token.
+type TraceStep struct {
+	// Token is the path element being processed.
+	Token string `json:"token"`
+
+	// Node is the dotted path of the matched node, empty for leftover tokens.
+	Node string `json:"node,omitempty"`
+
+	// Action is one of expand, query, port, wildcard, passthrough or schema.
+	Action string `json:"action"`
+
+	// Args are the tokens consumed by positional placeholders.
+	Args []string `json:"args,omitempty"`
+
+	// Output is what was written to the result for this token.
+	Output string `json:"output"`
+}
+
+// record appends a trace step, if tracing is enabled. start is the length of the
+// result before the token was processed.
+func (e *expansion) record(tok string, n *Node, action string, args []string, start int) {
+	if !e.tracing {
+		return
+	}
+	step := TraceStep{Token: tok, Action: action, Args: args, Output: e.res.String()[start:]}
+	if n != nil {
+		step.Node = n.path
 	}
+	e.steps = append(e.steps, step)
+}
 
-	p := c.Path(portKey).Data()
-	if p != nil {
-		if s, ok := p.(float64); ok {
-			return fmt.Sprintf(":%.f", s), port, nil
-		}
-		return "", 0, fmt.Errorf("casting port key to float64 failed for %T:%v", p, p)
+// actionName returns the name of a node action, as used in traces.
+func actionName(action int) string {
+	switch action {
+	case expand:
+		return expandKey
+	case query:
+		return queryKey
+	case port:
+		return portKey
 	}
+	return "none"
+}
 
-	return "", 0, fmt.Errorf("error in Config, no key matching 'expand', 'query', 'port' or 'schema' in %s", c.String())
+// separator returns the string written between two user supplied tokens.
+func (e *expansion) separator() string {
+	if e.query != nil {
+		return e.query.QuerySep
+	}
+	return "/"
 }
 
-// ExpandPath takes a Config, list of tokens (parsed from request) and the results buffer
-// At each level of recursion, it matches the token to the action described in the Config, and writes it
-// to the result buffer. There is special care needed to handle slashes correctly, which makes this function
-// quite nontrivial. Tests are crucial to ensure correctness.
-func ExpandPath(c *gabs.Container, token *list.Element, res *bytes.Buffer) error {
-	return expandPath(c, token, res, true)
+// writeToken writes a user supplied token, percent-encoding it inside a query.
+func (e *expansion) writeToken(tok string) {
+	if e.query != nil && !e.query.QueryRaw {
+		tok = url.QueryEscape(tok)
+	}
+	e.res.WriteString(tok)
 }
 
 // Internal helper function that adds contextual information about whether a leading slash
 // should be added to the beginning of the path
-func expandPath(c *gabs.Container, token *list.Element, res *bytes.Buffer, prependSlash bool) error {
+func (e *expansion) expandPath(c *Node, token *list.Element, prependSlash bool) error {
 	if token == nil {
 		return nil
 	}
@@ -81,22 +140,33 @@ func expandPath(c *gabs.Container, token *list.Element, res *bytes.Buffer, prepe
 		return fmt.Errorf("configuration is nil at token '%s'", token.Value)
 	}
 
-	children := c.ChildrenMap()
+	res := e.res
 	tokVal := token.Value.(string)
-	if child, ok := children[tokVal]; !isReserved(tokVal) && ok {
-		p, action, err := getPrefix(child)
-		if err != nil {
-			return fmt.Errorf("failed to get prefix for token '%s': %w", tokVal, err)
-		}
-
+	if child, ok := c.Children[tokVal]; ok {
+		e.last = child
+		p := child.prefix
 		prependChildSlash := true
+		next := token.Next()
+		start := res.Len()
+		var args []string
 
-		switch action {
+		switch child.action {
 		case expand: // Generic case: maybe write slash, then expanded token.
 			if prependSlash {
 				res.WriteString("/")
 			}
-			res.WriteString(p)
+			if child.template != nil {
+				// Placeholders consume the following tokens, recurse on whatever is left.
+				consumed := next
+				next = child.writeTemplate(res, next)
+				for t := consumed; e.tracing && t != next; t = t.Next() {
+					if tok := t.Value.(string); tok != "" {
+						args = append(args, tok)
+					}
+				}
+			} else {
+				res.WriteString(p)
+			}
 
 		case query: // Maybe write a slash, then expanded query, then recurse with no prepended slashes
 			if prependSlash {
@@ -104,53 +174,128 @@ func expandPath(c *gabs.Container, token *list.Element, res *bytes.Buffer, prepe
 			}
 			res.WriteString(p)
 			prependChildSlash = false
+			e.query = child
 
 		case port: // A little bit of a special case - unlike "expand" and "query", we never want a leading slash.
 			res.WriteString(p)
 
 		default:
-			return fmt.Errorf("invalid action type %d for token '%s'", action, tokVal)
+			return fmt.Errorf("error in Config, no key matching 'expand', 'query' or 'port' for token '%s'", tokVal)
 		}
+		e.record(tokVal, child, actionName(child.action), args, start)
 
-		if err := expandPath(child, token.Next(), res, prependChildSlash); err != nil {
+		if err := e.expandPath(child, next, prependChildSlash); err != nil {
 			return fmt.Errorf("failed to expand path for token '%s': %w", tokVal, err)
 		}
 		return nil
-	} else if child, ok := children[passKey]; ok {
+	} else if child := c.Wildcard; child != nil {
+		e.last = child
+		start := res.Len()
 		if prependSlash {
-			res.WriteString("/")
+			res.WriteString(e.separator())
 		}
-		res.WriteString(token.Value.(string))
-		if err := expandPath(child, token.Next(), res, true); err != nil {
+		e.writeToken(tokVal)
+		e.record(tokVal, child, "wildcard", nil, start)
+		if err := e.expandPath(child, token.Next(), true); err != nil {
 			return fmt.Errorf("failed to expand pass-through path for token '%s': %w", tokVal, err)
 		}
 		return nil
 	}
 
+	if e.query == nil && len(c.Children) > 0 {
+		e.miss, e.missNode = token, c
+	}
+
 	// if tokens left over, append the rest
-	for e := token; e != nil; e = e.Next() {
+	for t := token; t != nil; t = t.Next() {
+		start := res.Len()
 		if prependSlash {
-			res.WriteString("/")
+			res.WriteString(e.separator())
 		} else {
 			prependSlash = true
 		}
-		res.WriteString(e.Value.(string))
+		e.writeToken(t.Value.(string))
+		e.record(t.Value.(string), nil, "passthrough", nil, start)
+		if e.tracing {
+			e.leftover = append(e.leftover, t.Value.(string))
+		}
 	}
 
 	return nil
 }
 
-func isReserved(pathElem string) bool {
-	switch pathElem {
-	case
-		expandKey,
-		queryKey,
-		portKey,
-		passKey,
-		schemaKey,
-		sslKey:
-		return true
-	default:
-		return false
+// placeholder matches positional parameters such as {1} in expansions.
+var placeholder = regexp.MustCompile(`\{(\d+)\}`)
+
+// templatePart is a literal chunk of an expansion, optionally followed by a placeholder.
+type templatePart struct {
+	literal string
+	arg     int // 1-based index of the token written after literal, 0 for none.
+}
+
+// parseTemplate splits an expansion on its {N} placeholders. It returns nil parts
+// if there are no placeholders, along with the highest placeholder index used.
+func parseTemplate(s string) ([]templatePart, int, error) {
+	matches := placeholder.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return nil, 0, nil
+	}
+
+	var parts []templatePart
+	maxArg, last := 0, 0
+	for _, m := range matches {
+		arg, err := strconv.Atoi(s[m[2]:m[3]])
+		if err != nil || arg < 1 {
+			return nil, 0, fmt.Errorf("invalid placeholder '%s' in '%s', placeholders are numbered from {1}", s[m[0]:m[1]], s)
+		}
+		parts = append(parts, templatePart{literal: s[last:m[0]], arg: arg})
+		if arg > maxArg {
+			maxArg = arg
+		}
+		last = m[1]
+	}
+	parts = append(parts, templatePart{literal: s[last:]})
+	return parts, maxArg, nil
+}
+
+// writeTemplate writes the node's expansion, filling placeholders with the tokens starting
+// at token. Empty tokens, as in "g/pr//zap", are skipped rather than filled in, and
+// placeholders without a matching token are left empty. Returns the first token that was
+// not consumed.
+func (n *Node) writeTemplate(res *bytes.Buffer, token *list.Element) *list.Element {
+	args := make([]string, n.args)
+	for i := 0; i < len(args) && token != nil; token = token.Next() {
+		if tok := token.Value.(string); tok != "" {
+			args[i] = tok
+			i++
+		}
+	}
+	for _, p := range n.template {
+		res.WriteString(p.literal)
+		if p.arg > 0 {
+			res.WriteString(args[p.arg-1])
+		}
+	}
+	return token
+}
+
+// appendQuery merges a raw query string into an expanded URL. If the URL already has a
+// query the parameters are joined with '&', and any fragment is kept at the end.
+func appendQuery(u, rawQuery string) string {
+	if rawQuery == "" {
+		return u
+	}
+
+	fragment := ""
+	if i := strings.IndexByte(u, '#'); i >= 0 {
+		u, fragment = u[:i], u[i:]
+	}
+
+	switch {
+	case !strings.Contains(u, "?"):
+		u += "?"
+	case !strings.HasSuffix(u, "?") && !strings.HasSuffix(u, "&"):
+		u += "&"
 	}
+	return u + rawQuery + fragment
 }
@@ -287,4 +287,173 @@ func TestExpander(t *testing.T) {
 			So(res.String(), ShouldEqual, "https://github.com/search?q=apache/kafka+connect")
 		})
 	})
+	Convey("Given 'j/123'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("j/123")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://jira.corp/browse/PROJ-123'", func() {
+			So(res.String(), ShouldEqual, "https://jira.corp/browse/PROJ-123")
+		})
+	})
+	Convey("Given 'j/123/comments'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("j/123/comments")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://jira.corp/browse/PROJ-123/comments'", func() {
+			So(res.String(), ShouldEqual, "https://jira.corp/browse/PROJ-123/comments")
+		})
+	})
+	Convey("Given 'gp/issmirnov/zap'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("gp/issmirnov/zap")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://github.com/issmirnov/zap/pulls'", func() {
+			So(res.String(), ShouldEqual, "https://github.com/issmirnov/zap/pulls")
+		})
+	})
+	Convey("Given 'gp/issmirnov/zap/extra'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("gp/issmirnov/zap/extra")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://github.com/issmirnov/zap/pulls/extra'", func() {
+			So(res.String(), ShouldEqual, "https://github.com/issmirnov/zap/pulls/extra")
+		})
+	})
+	Convey("Given 'gp/issmirnov'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("gp/issmirnov")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://github.com/issmirnov//pulls'", func() {
+			So(res.String(), ShouldEqual, "https://github.com/issmirnov//pulls")
+		})
+	})
+	Convey("Given 'g/pr/zap/42'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("g/pr/zap/42")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://github.com/issmirnov/zap/pull/42'", func() {
+			So(res.String(), ShouldEqual, "https://github.com/issmirnov/zap/pull/42")
+		})
+	})
+	Convey("Given 'g/pr//zap//42'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("g/pr//zap//42")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("empty tokens should not fill placeholders", func() {
+			So(res.String(), ShouldEqual, "https://github.com/issmirnov/zap/pull/42")
+		})
+	})
+	Convey("Given 'g/s/foo bar&baz'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("g/s/foo bar&baz")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://github.com/search?q=foo+bar%26baz'", func() {
+			So(res.String(), ShouldEqual, "https://github.com/search?q=foo+bar%26baz")
+		})
+	})
+	Convey("Given 'g/s/foo/bar?baz'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("g/s/foo/bar?baz")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://github.com/search?q=foo/bar%3Fbaz'", func() {
+			So(res.String(), ShouldEqual, "https://github.com/search?q=foo/bar%3Fbaz")
+		})
+	})
+	Convey("Given 'g/sp/golang/context/cancel'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("g/sp/golang/context/cancel")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://github.com/search?q=golang+context+cancel'", func() {
+			So(res.String(), ShouldEqual, "https://github.com/search?q=golang+context+cancel")
+		})
+	})
+	Convey("Given 'g/sr/foo bar&baz'", t, func() {
+		c, _ := loadTestYaml()
+		l := tokenize("g/sr/foo bar&baz")
+		var res bytes.Buffer
+		res.WriteString(httpsPrefix)
+
+		err := ExpandPath(c, l.Front(), &res)
+		So(err, ShouldBeNil)
+
+		Convey("result should equal 'https://github.com/search?q=foo bar&baz'", func() {
+			So(res.String(), ShouldEqual, "https://github.com/search?q=foo bar&baz")
+		})
+	})
+}
+
+func TestParseTemplate(t *testing.T) {
+	Convey("Given an expansion without placeholders", t, func() {
+		parts, args, err := parseTemplate("github.com/{foo}")
+		So(err, ShouldBeNil)
+		So(parts, ShouldBeNil)
+		So(args, ShouldEqual, 0)
+	})
+
+	Convey("Given an expansion with out of order placeholders", t, func() {
+		parts, args, err := parseTemplate("{2}-{1}")
+		So(err, ShouldBeNil)
+		So(args, ShouldEqual, 2)
+		So(parts, ShouldResemble, []templatePart{{"", 2}, {"-", 1}, {"", 0}})
+	})
+}
+
+func TestAppendQuery(t *testing.T) {
+	Convey("Given expanded URLs and an incoming query string", t, func() {
+		So(appendQuery("https://github.com/issmirnov/zap", ""), ShouldEqual, "https://github.com/issmirnov/zap")
+		So(appendQuery("https://github.com/issmirnov/zap", "tab=readme"), ShouldEqual, "https://github.com/issmirnov/zap?tab=readme")
+		So(appendQuery("https://github.com/search?q=foo", "type=code"), ShouldEqual, "https://github.com/search?q=foo&type=code")
+		So(appendQuery("https://example.com/?", "a=b"), ShouldEqual, "https://example.com/?a=b")
+		So(appendQuery("chrome://net-internals/#dns", "x=1"), ShouldEqual, "chrome://net-internals/?x=1#dns")
+	})
 }
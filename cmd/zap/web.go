@@ -2,79 +2,103 @@ package zap
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"encoding/json"
-
-	"github.com/Jeffail/gabs/v2"
 )
 
 // IndexHandler handles all the non status expansions.
 func IndexHandler(ctx *Context, w http.ResponseWriter, r *http.Request) (int, error) {
 	// Check if context and configuration are valid
-	if ctx == nil || ctx.Config == nil {
+	if ctx == nil {
+		return http.StatusInternalServerError, fmt.Errorf("server configuration is invalid or not loaded")
+	}
+	conf := ctx.Config()
+	if conf == nil {
 		return http.StatusInternalServerError, fmt.Errorf("server configuration is invalid or not loaded")
 	}
 
-	var host string
-	if r.Header.Get("X-Forwarded-Host") != "" {
-		host = r.Header.Get("X-Forwarded-Host")
-	} else {
-		host = r.Host
+	host := requestHost(r)
+
+	// Requests for zap itself get the directory of shortcuts.
+	if _, _, ok := conf.lookupHost(host); !ok && r.URL.Path == "/" && isSelfHost(ctx, host) {
+		return directory(conf, w)
 	}
 
-	var hostConfig *gabs.Container
-	var ok bool
+	start := time.Now()
+	res, err := NewResolver(conf).ResolveRequest(r)
+	ctx.metrics.observeExpansion(time.Since(start))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusInternalServerError, err
+	}
+	ctx.Stats.Record(res.Node, start)
+	setTarget(r, res.URL)
 
-	// Check if host present in Config.
-	children := ctx.Config.ChildrenMap()
-	if hostConfig, ok = children[host]; !ok {
-		return http.StatusNotFound, fmt.Errorf("shortcut '%s' not found in config", host)
+	if res.Proxy {
+		return serveProxy(ctx, w, r, res.URL)
 	}
 
-	tokens := tokenize(host + r.URL.Path)
+	// send result
+	http.Redirect(w, r, res.URL, res.Status)
 
-	// Set up handles on token and Config. We might need to skip ahead if there's a custom schema set.
-	tokensStart := tokens.Front()
-	conf := ctx.Config
+	return res.Status, nil
+}
 
-	var path bytes.Buffer
-	if s := hostConfig.Path(sslKey).Data(); s != nil && s.(bool) {
-		path.WriteString(httpPrefix)
-	} else if s := hostConfig.Path(schemaKey).Data(); s != nil && s.(string) != "" {
-		schema := hostConfig.Path(schemaKey).Data().(string)
-		if schema == "" {
-			return http.StatusInternalServerError, fmt.Errorf("invalid schema configuration for host '%s'", host)
-		}
-		path.WriteString(schema + ":/")
-		// move one token ahead to parse expansions correctly.
-		conf = conf.ChildrenMap()[tokensStart.Value.(string)]
-		tokensStart = tokensStart.Next()
-	} else {
-		// Default to regular https prefix.
-		path.WriteString(httpsPrefix)
-	}
+// ExpandPreviewPath serves the expansion preview API, unless moved with WithAdminPrefix.
+const ExpandPreviewPath = DefaultAdminPrefix + "/expand"
+
+// Preview describes how a shortcut expands. It is returned by the preview API and
+// printed by "zap expand --json".
+type Preview struct {
+	// Query is the shortcut that was expanded, as passed in.
+	Query string `json:"query"`
+
+	Result
+}
 
-	// Validate that we have a valid configuration before expanding
+// ExpandHandler responds to /_zap/expand?q=g/s/foo with a JSON Preview of how the
+// shortcut expands, without redirecting.
+func ExpandHandler(ctx *Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	conf := ctx.Config()
 	if conf == nil {
-		return http.StatusInternalServerError, fmt.Errorf("invalid configuration structure for host '%s'", host)
+		return http.StatusInternalServerError, fmt.Errorf("configuration not loaded or invalid")
 	}
 
-	if err := ExpandPath(conf, tokensStart, &path); err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("failed to expand path for host '%s': %w", host, err)
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing 'q' parameter, e.g. %s?q=g/s/foo", r.URL.Path)
 	}
 
-	// Validate that we generated a valid path
-	if path.Len() == 0 {
-		return http.StatusInternalServerError, fmt.Errorf("failed to generate redirect path for host '%s'", host)
+	res, err := (&Resolver{Config: conf, Trace: true}).ResolveShortcut(q)
+	if err != nil {
+		var nf *notFoundError
+		if errors.As(err, &nf) {
+			return writeJSON(w, http.StatusNotFound, nf)
+		}
+		return http.StatusInternalServerError, err
 	}
+	return writeJSON(w, http.StatusOK, Preview{Query: q, Result: res})
+}
 
-	// send result
-	http.Redirect(w, r, path.String(), http.StatusFound)
-
-	return http.StatusFound, nil
+// writeJSON sends v as an indented JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) (int, error) {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to encode response: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to write response: %w", err)
+	}
+	return status, nil
 }
 
 // HealthHandler responds to /healthz request.
@@ -90,12 +114,18 @@ func HealthHandler(w http.ResponseWriter, r *http.Request) {
 // VarsHandler responds to /varz request and prints Config.
 func VarsHandler(c *Context, w http.ResponseWriter, r *http.Request) (int, error) {
 	// Validate that we have a valid configuration
-	if c.Config == nil {
+	conf := c.Config()
+	if conf == nil {
 		return http.StatusInternalServerError, fmt.Errorf("configuration not loaded or invalid")
 	}
 
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+
 	w.WriteHeader(http.StatusOK)
-	_, err := io.WriteString(w, jsonPrettyPrint(c.Config.String()))
+	_, err = io.WriteString(w, jsonPrettyPrint(string(data)))
 	if err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("failed to write response: %w", err)
 	}
@@ -1,13 +1,14 @@
 package zap
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
-	"github.com/Jeffail/gabs/v2"
-	"github.com/ghodss/yaml"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -19,7 +20,7 @@ func TestIndexHandler(t *testing.T) {
 	Convey("Given app is set up with default Config", t, func() {
 		c, err := loadTestYaml()
 		So(err, ShouldBeNil)
-		context := &Context{Config: c}
+		context := NewContext(c)
 		appHandler := &CtxWrapper{context, IndexHandler}
 		handler := http.Handler(appHandler)
 		Convey("When we GET http://g/z", func() {
@@ -112,6 +113,96 @@ func TestIndexHandler(t *testing.T) {
 				So(rr.Code, ShouldEqual, http.StatusNotFound)
 			})
 		})
+		Convey("When we GET http://gh/z from a browser", func() {
+			req, err := http.NewRequest("GET", "/z", nil)
+			So(err, ShouldBeNil)
+			req.Host = "gh"
+			req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be an HTML 404 suggesting close shortcuts", func() {
+				So(rr.Code, ShouldEqual, http.StatusNotFound)
+				So(rr.Header().Get("Content-Type"), ShouldStartWith, "text/html")
+				So(rr.Body.String(), ShouldContainSubstring, `<a href="//g/z">g/z</a>`)
+			})
+		})
+		Convey("When we GET http://gh/z as JSON", func() {
+			req, err := http.NewRequest("GET", "/z", nil)
+			So(err, ShouldBeNil)
+			req.Host = "gh"
+			req.Header.Set("Accept", "application/json")
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a JSON 404 with suggestions and valid keys", func() {
+				So(rr.Code, ShouldEqual, http.StatusNotFound)
+				var resp notFoundError
+				So(json.Unmarshal(rr.Body.Bytes(), &resp), ShouldBeNil)
+				So(resp.Message, ShouldEqual, "shortcut 'gh/z' not found in config")
+				So(resp.Suggestions, ShouldContain, "g/z")
+				So(resp.Children, ShouldContain, "g")
+			})
+		})
+		Convey("When we GET http://gh/z with curl", func() {
+			req, err := http.NewRequest("GET", "/z", nil)
+			So(err, ShouldBeNil)
+			req.Host = "gh"
+			req.Header.Set("Accept", "*/*")
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a plain text 404 with suggestions", func() {
+				So(rr.Code, ShouldEqual, http.StatusNotFound)
+				So(rr.Body.String(), ShouldContainSubstring, "Did you mean: g/z")
+			})
+		})
+		Convey("When we GET http://g/zz, a typo below a known shortcut", func() {
+			req, err := http.NewRequest("GET", "/zz", nil)
+			So(err, ShouldBeNil)
+			req.Host = "g"
+			req.Header.Set("Accept", "application/json")
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The unknown element should be passed through rather than a 404", func() {
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://github.com/zz")
+			})
+		})
+		Convey("When we GET http://a/0/x, with a zero for the letter o", func() {
+			n, err := parseYamlString("a:\n  expand: amazon.com\n  o:\n    expand: orders\n  c:\n    expand: cart\n")
+			So(err, ShouldBeNil)
+			context.SetConfig(n)
+			req, err := http.NewRequest("GET", "/0/x", nil)
+			So(err, ShouldBeNil)
+			req.Host = "a"
+			req.Header.Set("Accept", "application/json")
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("We should get the valid keys under the deepest matched node", func() {
+				So(rr.Code, ShouldEqual, http.StatusNotFound)
+				var nf notFoundError
+				So(json.Unmarshal(rr.Body.Bytes(), &nf), ShouldBeNil)
+				So(nf.Shortcut, ShouldEqual, "a/0/x")
+				So(nf.Matched, ShouldEqual, "a")
+				So(nf.Suggestions, ShouldResemble, []string{"a/c/x", "a/o/x"})
+				So(nf.Children, ShouldResemble, []string{"c", "o"})
+			})
+			Convey("Other unknown elements should still be passed through", func() {
+				req.URL.Path = "/z"
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://amazon.com/z")
+			})
+		})
 		Convey("When we GET http://g/s/", func() {
 			req, err := http.NewRequest("GET", "/s/", nil)
 			So(err, ShouldBeNil)
@@ -138,6 +229,19 @@ func TestIndexHandler(t *testing.T) {
 				So(rr.Header().Get("Location"), ShouldEqual, "https://github.com/search?q=foo")
 			})
 		})
+		Convey("When we GET http://g/s/foo%20bar%26baz", func() {
+			req, err := http.NewRequest("GET", "/s/foo%20bar%26baz", nil)
+			So(err, ShouldBeNil)
+			req.Host = "g"
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The query should be percent-encoded", func() {
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://github.com/search?q=foo+bar%26baz")
+			})
+		})
 		Convey("When we GET http://g/s", func() {
 			req, err := http.NewRequest("GET", "/s", nil)
 			So(err, ShouldBeNil)
@@ -292,12 +396,103 @@ func TestIndexHandler(t *testing.T) {
 			})
 		})
 
+		Convey("When we GET http://g/z?tab=readme", func() {
+			req, err := http.NewRequest("GET", "/z?tab=readme", nil)
+			So(err, ShouldBeNil)
+			req.Host = "g"
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a 302 and the query string should be appended", func() {
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://github.com/issmirnov/zap?tab=readme")
+			})
+		})
+		Convey("When we GET http://g/s/foo?type=code", func() {
+			req, err := http.NewRequest("GET", "/s/foo?type=code", nil)
+			So(err, ShouldBeNil)
+			req.Host = "g"
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a 302 and the query string should be merged with the expanded query", func() {
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://github.com/search?q=foo&type=code")
+			})
+		})
+		Convey("When we GET http://ch/n/d?x=1", func() {
+			req, err := http.NewRequest("GET", "/n/d?x=1", nil)
+			So(err, ShouldBeNil)
+			req.Host = "ch"
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a 302 and the query string should be inserted before the fragment", func() {
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "chrome://net-internals/?x=1#dns")
+			})
+		})
+		Convey("When we GET http://ak/hi?x=1", func() {
+			req, err := http.NewRequest("GET", "/hi?x=1", nil)
+			So(err, ShouldBeNil)
+			req.Host = "ak"
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a 302 and the query string should be dropped for nodes under drop_query", func() {
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://kafka.apache.org/contact")
+			})
+		})
+		Convey("When we GET http://ak/q?x=1", func() {
+			req, err := http.NewRequest("GET", "/q?x=1", nil)
+			So(err, ShouldBeNil)
+			req.Host = "ak"
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a 302 and a descendant should be able to re-enable the query string", func() {
+				So(rr.Code, ShouldEqual, http.StatusFound)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://kafka.apache.org/quickstart?x=1")
+			})
+		})
+		Convey("When we GET http://e/b", func() {
+			req, err := http.NewRequest("GET", "/b", nil)
+			So(err, ShouldBeNil)
+			req.Host = "e"
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a 301 to https://example.com/bananas, the status should be inherited from the parent", func() {
+				So(rr.Code, ShouldEqual, http.StatusMovedPermanently)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://example.com/bananas")
+			})
+		})
+		Convey("When we GET http://e/a", func() {
+			req, err := http.NewRequest("GET", "/a", nil)
+			So(err, ShouldBeNil)
+			req.Host = "e"
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			Convey("The result should be a 307 to https://example.com/apples, the status set on the node itself should win", func() {
+				So(rr.Code, ShouldEqual, http.StatusTemporaryRedirect)
+				So(rr.Header().Get("Location"), ShouldEqual, "https://example.com/apples")
+			})
+		})
 	})
 }
 
 func TestIndexHandlerErrorHandling(t *testing.T) {
 	// Test case: nil configuration
-	ctx := &Context{Config: nil}
+	ctx := NewContext(nil)
 	req := httptest.NewRequest("GET", "/", nil)
 	req.Host = "test.com"
 	w := httptest.NewRecorder()
@@ -311,8 +506,7 @@ func TestIndexHandlerErrorHandling(t *testing.T) {
 	}
 
 	// Test case: host not found in configuration
-	config := gabs.New()
-	ctx = &Context{Config: config}
+	ctx = NewContext(&Node{})
 	req = httptest.NewRequest("GET", "/", nil)
 	req.Host = "nonexistent.com"
 	w = httptest.NewRecorder()
@@ -330,7 +524,7 @@ func TestIndexHandlerErrorHandling(t *testing.T) {
 // Run with go test -run=BenchmarkIndexHandler -bench=. // results: 500000x	2555 ns/op
 func BenchmarkIndexHandler(b *testing.B) {
 	c, _ := loadTestYaml()
-	context := &Context{Config: c}
+	context := NewContext(c)
 	appHandler := &CtxWrapper{context, IndexHandler}
 	handler := http.Handler(appHandler)
 	req, _ := http.NewRequest("GET", "/z", nil)
@@ -363,7 +557,7 @@ func TestVarzHandler(t *testing.T) {
 	Convey("Given app is set up with default Config", t, func() {
 		c, err := loadTestYaml()
 		So(err, ShouldBeNil)
-		context := &Context{Config: c}
+		context := NewContext(c)
 
 		appHandler := &CtxWrapper{context, VarsHandler}
 		handler := http.Handler(appHandler)
@@ -379,22 +573,135 @@ func TestVarzHandler(t *testing.T) {
 				So(rr.Code, ShouldEqual, http.StatusOK)
 			})
 			Convey("It should be valid json", func() {
-				_, err := yaml.YAMLToJSON(rr.Body.Bytes())
-				So(err, ShouldBeNil)
+				So(json.Valid(rr.Body.Bytes()), ShouldBeTrue)
 			})
 			Convey("It should equal the Config file", func() {
-				conf, err := yaml.YAMLToJSON(c.Bytes())
+				n, err := parseYamlString(`
+g:
+  expand: github.com
+  s:
+    query: search?q=
+    query_sep: "-"
+    query_raw: yes
+  z:
+    expand: issmirnov/zap
+    drop_query: yes
+    status: 301
+l:
+  expand: localhost
+  ssl_off: yes
+  p:
+    port: 8080
+    proxy: yes
+ch:
+  schema: chrome
+  v:
+    expand: version
+ak:
+  expand: kafka.apache.org
+  "*":
+    d:
+      expand: documentation.html
+`)
 				So(err, ShouldBeNil)
+				context.SetConfig(n)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+
+				// encoding/json sorts map keys, so the output is deterministic.
+				var got bytes.Buffer
+				So(json.Compact(&got, rr.Body.Bytes()), ShouldBeNil)
+				So(got.String(), ShouldEqual, `{`+
+					`"ak":{"*":{"d":{"expand":"documentation.html"}},"expand":"kafka.apache.org"},`+
+					`"ch":{"schema":"chrome","v":{"expand":"version"}},`+
+					`"g":{"expand":"github.com",`+
+					`"s":{"query":"search?q=","query_raw":true,"query_sep":"-"},`+
+					`"z":{"drop_query":true,"expand":"issmirnov/zap","status":301}},`+
+					`"l":{"expand":"localhost","p":{"port":8080,"proxy":true},"ssl_off":true}}`)
+			})
+			Convey("It should render typed fields in the config file shape", func() {
+				var resp map[string]interface{}
+				So(json.Unmarshal(rr.Body.Bytes(), &resp), ShouldBeNil)
+				So(resp["g"].(map[string]interface{})["expand"], ShouldEqual, "github.com")
+				So(resp["z"].(map[string]interface{})["ssl_off"], ShouldEqual, true)
+				So(resp["l"].(map[string]interface{})["a"].(map[string]interface{})["port"], ShouldEqual, 8080)
+				So(resp["ak"].(map[string]interface{}), ShouldContainKey, "*")
+			})
+		})
+	})
+}
 
-				resp, err := yaml.YAMLToJSON(rr.Body.Bytes())
-				So(err, ShouldBeNil)
+func TestExpandHandler(t *testing.T) {
+	Convey("Given app is set up with default Config", t, func() {
+		c, err := loadTestYaml()
+		So(err, ShouldBeNil)
+		handler := http.Handler(&CtxWrapper{NewContext(c), ExpandHandler})
 
-				// This does not work: "So(resp, ShouldEqual, []byte(jsonPrettyPrint(string(conf))))"
-				// We get a nicely formatted response, but when we feed it into YAMLToJSON it collapses our nice
-				// newlines. As a result, directly comparing the byte arrays here is a nogo. Therefore, we cheat
-				// and utilize the separately tested jsonPrettyPrint to idempotently indent the JSON and compare that.
-				So(jsonPrettyPrint(string(resp)), ShouldEqual, jsonPrettyPrint(string(conf)))
+		get := func(q string) (*httptest.ResponseRecorder, Preview) {
+			req := httptest.NewRequest("GET", ExpandPreviewPath+"?q="+url.QueryEscape(q), nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			var resp Preview
+			_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+			return rr, resp
+		}
+
+		Convey("When we preview g/s/foo/bar", func() {
+			rr, resp := get("g/s/foo/bar")
+
+			Convey("We should get the URL along with every step taken", func() {
+				So(rr.Code, ShouldEqual, http.StatusOK)
+				So(rr.Header().Get("Content-Type"), ShouldEqual, "application/json")
+				So(resp.URL, ShouldEqual, "https://github.com/search?q=foo/bar")
+				So(resp.Schema, ShouldEqual, "https")
+				So(resp.Status, ShouldEqual, http.StatusFound)
+				So(resp.Steps, ShouldResemble, []TraceStep{
+					{Token: "g", Node: "g", Action: "expand", Output: "/github.com"},
+					{Token: "s", Node: "g.s", Action: "query", Output: "/search?q="},
+					{Token: "foo", Action: "passthrough", Output: "foo"},
+					{Token: "bar", Action: "passthrough", Output: "/bar"},
+				})
+				So(resp.Leftover, ShouldResemble, []string{"foo", "bar"})
 			})
 		})
+
+		Convey("When we preview shortcuts with wildcards, ports, placeholders and schemas", func() {
+			_, resp := get("ak/23/j")
+			So(resp.Steps[1], ShouldResemble, TraceStep{Token: "23", Node: "ak.*", Action: "wildcard", Output: "/23"})
+
+			_, resp = get("l/a/s")
+			So(resp.URL, ShouldEqual, "http://localhost:8080/service")
+			So(resp.Schema, ShouldEqual, "http")
+			So(resp.Steps[1].Action, ShouldEqual, "port")
+
+			_, resp = get("gp/issmirnov/zap")
+			So(resp.Steps[0].Args, ShouldResemble, []string{"issmirnov", "zap"})
+			So(resp.Leftover, ShouldBeEmpty)
+
+			rr, _ := get("g/z")
+			So(rr.Body.String(), ShouldContainSubstring, `"leftover": []`)
+
+			_, resp = get("ch/v")
+			So(resp.URL, ShouldEqual, "chrome://version")
+			So(resp.Schema, ShouldEqual, "chrome")
+			So(resp.Steps[0].Action, ShouldEqual, "schema")
+		})
+
+		Convey("When we preview a shortcut with a query string", func() {
+			_, resp := get("g/z?tab=readme")
+			So(resp.URL, ShouldEqual, "https://github.com/issmirnov/zap?tab=readme")
+		})
+
+		Convey("When we preview an unknown shortcut", func() {
+			rr, _ := get("gh/z")
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+			So(rr.Body.String(), ShouldContainSubstring, `"g/z"`)
+		})
+
+		Convey("When we leave out the q parameter", func() {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", ExpandPreviewPath, nil))
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		})
 	})
 }